@@ -2,23 +2,27 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
-	"net"
+	"math"
+	"mime/multipart"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/goccy/go-graphviz"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/lioia/distributed-pagerank/pkg/node"
+	"github.com/lioia/distributed-pagerank/pkg/graph"
 	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
-	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type TemplateRenderer struct {
@@ -29,41 +33,71 @@ func (t TemplateRenderer) Render(w io.Writer, name string, data interface{}, e e
 	return t.tmpl.ExecuteTemplate(w, name, data)
 }
 
+// Nodes rendered in the results page (the ones with the highest rank)
+const renderNodes = 60
+
+// Nodes shown in a page of the results
+const pageNodes = 50
+
+// Jobs submitted by the client: the results are fetched from their master
+type Jobs struct {
+	mu   sync.Mutex
+	apis map[string]string // Job -> master API
+}
+
+// Connection to the master of the job (has to be closed)
+func (j *Jobs) api(job string) (utils.Client[proto.APIClient], error) {
+	j.mu.Lock()
+	apiUrl, ok := j.apis[job]
+	j.mu.Unlock()
+	if !ok {
+		return utils.Client[proto.APIClient]{}, fmt.Errorf("Unknown job %s", job)
+	}
+	return utils.ApiCall(apiUrl)
+}
+
+// Node in a page of the results
+type RankRow struct {
+	Position int32
+	Id       int32
+	Label    string
+	Rank     float64
+}
+
+// Job in the list of the jobs submitted to a master
+type JobRow struct {
+	Job       string
+	Status    string
+	Nodes     int32
+	Iteration int32
+	Submitted string
+}
+
 type IndexPage struct {
 	Status     string
+	Job        string
+	Iteration  int32
+	Seed       int64
 	Master     string
 	Dot        string
-	Base64Dot  string
-	Values     map[int32]float64
+	Svg        template.HTML
+	Rows       []RankRow
+	Labelled   bool // Nodes have labels: their IDs (used to find them) are shown too
+	Order      string
+	Total      int32
+	Prev       int32 // Offset of the previous page (-1 if there is none)
+	Next       int32 // Offset of the next page (-1 if there is none)
+	Exports    []string
+	Jobs       []JobRow
 	Error      string
 	FormErrors map[string]string
 }
 
 func main() {
 	_ = godotenv.Load()
-	host, err := utils.ReadStringEnvVar("HOST")
-	utils.FailOnError("Failed to load environment variables", err)
-	rpcPort, err := utils.ReadIntEnvVar("RPC_PORT")
-	utils.FailOnError("Failed to load environment variables", err)
 	webPort := utils.ReadIntEnvVarOr("WEB_PORT", 80)
 
-	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, rpcPort))
-	utils.FailOnError("Failed to listen for node server", err)
-	fmt.Printf("Starting client API server on: %s:%d\n", host, rpcPort)
-
-	ranks := make(chan *proto.Ranks)
-	iteration := make(chan int32)
-	// Create gRPC server
-	go func() {
-		defer lis.Close()
-		server := grpc.NewServer()
-		proto.RegisterAPIServer(server, &node.ApiServerImpl{
-			Ranks:      ranks,
-			Iterations: iteration,
-		})
-		err = server.Serve(lis)
-		utils.FailOnError("Failed to serve", err)
-	}()
+	jobs := &Jobs{apis: make(map[string]string)}
 
 	tmpls, err := template.ParseFiles(
 		"public/index.html",
@@ -79,13 +113,31 @@ func main() {
 
 	e.GET("/", index)
 
+	e.GET("/jobs", func(c echo.Context) error {
+		return listJobs(c, jobs)
+	})
+	e.GET("/jobs/:job", func(c echo.Context) error {
+		// Completed jobs are shown by the first message of the watcher
+		return c.Render(200, "status", IndexPage{Job: c.Param("job"), Iteration: -1, Status: "0"})
+	})
 	e.POST("/ranks/new", func(c echo.Context) error {
-		return newRanks(c, fmt.Sprintf("%s:%d", host, rpcPort))
+		return newRanks(c, jobs)
+	})
+	e.GET("/ranks/:job", func(c echo.Context) error {
+		return sseRanks(c, tmpls, jobs)
+	})
+	e.GET("/ranks/:job/page", func(c echo.Context) error {
+		return ranksPage(c, jobs)
 	})
-	e.GET("/ranks", func(c echo.Context) error {
-		return sseRanks(c, ranks, iteration, tmpls)
+	e.POST("/ranks/:job/cancel", func(c echo.Context) error {
+		return cancelRanks(c, jobs)
+	})
+	e.GET("/export/:job/:format", func(c echo.Context) error {
+		return export(c, jobs)
+	})
+	e.GET("/render/:job", func(c echo.Context) error {
+		return sseRender(c, tmpls, jobs)
 	})
-	e.GET("/render/:dot", sseRender)
 	log.Println("Starting web server")
 	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", webPort)))
 }
@@ -94,81 +146,330 @@ func index(c echo.Context) error {
 	return c.Render(200, "index.html", nil)
 }
 
-func sseRanks(c echo.Context, ranks chan *proto.Ranks, iteration chan int32, tmpls *template.Template) error {
+// Jobs submitted to the master (including the ones completed before a restart)
+func listJobs(c echo.Context, jobs *Jobs) error {
+	apiUrl := c.QueryParam("api")
+	if len(strings.Split(apiUrl, ":")) != 2 {
+		return c.Render(200, "jobs", IndexPage{Error: "Invalid API Url (Expecting host:port)"})
+	}
+	api, err := utils.ApiCall(apiUrl)
+	if err != nil {
+		return c.Render(200, "jobs", IndexPage{Error: fmt.Sprintf("Failed to contact API: %v", err)})
+	}
+	defer api.Close()
+	list, err := api.Client.ListJobs(api.Ctx, &emptypb.Empty{})
+	if err != nil {
+		return c.Render(200, "jobs", IndexPage{Error: fmt.Sprintf("Failed to list jobs: %v", err)})
+	}
+	data := IndexPage{}
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	// Most recent first
+	for i := len(list.Jobs) - 1; i >= 0; i-- {
+		info := list.Jobs[i]
+		jobs.apis[info.Job] = apiUrl
+		data.Jobs = append(data.Jobs, JobRow{
+			Job:       info.Job,
+			Status:    strings.ToLower(strings.TrimPrefix(info.Status.String(), "JOB_")),
+			Nodes:     info.Nodes,
+			Iteration: info.Iteration,
+			Submitted: time.UnixMilli(info.Submitted).Format(time.DateTime),
+		})
+	}
+	return c.Render(200, "jobs", data)
+}
+
+// Watch the job on the master: the progress after iteration (query parameter)
+// or the final ranks are sent as a single message
+func sseRanks(c echo.Context, tmpls *template.Template, jobs *Jobs) error {
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
+	job := c.Param("job")
+	last, err := strconv.Atoi(c.QueryParam("iteration"))
+	if err != nil {
+		last = -1
+	}
+	api, err := jobs.api(job)
+	if err != nil {
+		fmt.Fprintf(c.Response().Writer, "data: <p class=\"text-error\">Failed to contact API: %v</p>\n\n", err)
+		return nil
+	}
+	defer api.Close()
+	stream, err := api.Client.WatchJob(c.Request().Context(), wrapperspb.String(job))
+	if err != nil {
+		fmt.Fprintf(c.Response().Writer, "data: <p class=\"text-error\">Failed to watch job: %v</p>\n\n", err)
+		return nil
+	}
 	for {
-		select {
-		case <-c.Request().Context().Done():
+		event, err := stream.Recv()
+		if err != nil {
+			fmt.Fprintf(c.Response().Writer, "data: <p class=\"text-error\">Failed to watch job: %v</p>\n\n", err)
 			return nil
-		case value := <-iteration:
-			var msgBuffer bytes.Buffer
-			var msg string
-			err := tmpls.ExecuteTemplate(&msgBuffer, "status", IndexPage{
-				Status: fmt.Sprintf("%d", value),
+		}
+		if values := event.Ranks; values != nil {
+			// First page of the ranks
+			page, err := api.Client.GetRanks(c.Request().Context(), &proto.RanksRequest{
+				Job:   job,
+				Limit: pageNodes,
 			})
 			if err != nil {
-				msg = fmt.Sprintf("Failed to read values: %+v", err)
-			} else {
-				msg = strings.ReplaceAll(msgBuffer.String(), "\n", "")
+				fmt.Fprintf(c.Response().Writer, "data: <p class=\"text-error\">Failed to get ranks: %v</p>\n\n", err)
+				return nil
 			}
-			fmt.Fprintf(c.Response().Writer, "data: %s\n\n", msg)
+			data := pageData(page, proto.RanksOrder_ORDER_RANK, 0)
+			data.Seed = values.Seed
+			data.Master = values.Master
+			data.Status = values.Status
+			data.Exports = graph.ExportFormats()
+			sendTemplate(c, tmpls, "ranks", data)
 			return nil
-		case values := <-ranks:
-			var msgBuffer bytes.Buffer
-			var msg string
-			base64Dot := base64.StdEncoding.EncodeToString([]byte(values.DotGraph))
-			if len(values.Ranks) > 60 {
-				base64Dot = "dot"
+		}
+		if event.Status == proto.JobStatus_JOB_CANCELLED {
+			sendTemplate(c, tmpls, "cancelled", IndexPage{Job: job})
+			return nil
+		}
+		if int(event.Iteration) <= last {
+			// Already shown: waiting for the next iteration
+			continue
+		}
+		sendTemplate(c, tmpls, "status", IndexPage{
+			Job:       job,
+			Iteration: event.Iteration,
+			Status:    fmt.Sprintf("%d, delta: %g", event.Iteration, event.Delta),
+		})
+		return nil
+	}
+}
+
+// Page of the ranks (offset and order) or the requested nodes (ids)
+func ranksPage(c echo.Context, jobs *Jobs) error {
+	job := c.Param("job")
+	request := &proto.RanksRequest{Job: job, Limit: pageNodes}
+	if order, ok := proto.RanksOrder_value[c.QueryParam("order")]; ok {
+		request.Order = proto.RanksOrder(order)
+	}
+	if offset, err := strconv.Atoi(c.QueryParam("offset")); err == nil {
+		request.Offset = int32(offset)
+	}
+	for _, token := range strings.Split(c.QueryParam("ids"), ",") {
+		if token = strings.TrimSpace(token); token == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(token, 10, 32)
+		if err != nil {
+			return c.Render(200, "ranks.page", IndexPage{Job: job, Error: fmt.Sprintf("Failed to parse node %s", token)})
+		}
+		request.Ids = append(request.Ids, int32(id))
+	}
+	api, err := jobs.api(job)
+	if err != nil {
+		return c.Render(200, "ranks.page", IndexPage{Job: job, Error: fmt.Sprintf("Failed to contact API: %v", err)})
+	}
+	defer api.Close()
+	page, err := api.Client.GetRanks(api.Ctx, request)
+	if err != nil {
+		return c.Render(200, "ranks.page", IndexPage{Job: job, Error: fmt.Sprintf("Failed to get ranks: %v", err)})
+	}
+	data := pageData(page, request.Order, request.Offset)
+	if len(request.Ids) > 0 {
+		// Requested nodes: no position and no pages
+		for i := range data.Rows {
+			data.Rows[i].Position = 0
+		}
+		data.Prev, data.Next = -1, -1
+	}
+	return c.Render(200, "ranks.page", data)
+}
+
+func pageData(page *proto.RanksPage, order proto.RanksOrder, offset int32) IndexPage {
+	data := IndexPage{
+		Job:   page.Job,
+		Order: order.String(),
+		Total: page.Total,
+		Prev:  -1,
+		Next:  -1,
+	}
+	for i, node := range page.Nodes {
+		data.Rows = append(data.Rows, RankRow{
+			Position: offset + int32(i) + 1,
+			Id:       node.Id,
+			Label:    node.Label,
+			Rank:     node.Rank,
+		})
+		if node.Label != strconv.FormatInt(int64(node.Id), 10) {
+			data.Labelled = true
+		}
+	}
+	if offset > 0 {
+		data.Prev = offset - pageNodes
+		if data.Prev < 0 {
+			data.Prev = 0
+		}
+	}
+	if offset+int32(len(page.Nodes)) < page.Total {
+		data.Next = offset + int32(len(page.Nodes))
+	}
+	return data
+}
+
+// Stop the job on the master (the watcher shows the cancelled status)
+func cancelRanks(c echo.Context, jobs *Jobs) error {
+	api, err := jobs.api(c.Param("job"))
+	if err != nil {
+		return c.String(500, fmt.Sprintf("Failed to contact API: %v", err))
+	}
+	defer api.Close()
+	if _, err := api.Client.CancelJob(api.Ctx, wrapperspb.String(c.Param("job"))); err != nil {
+		return c.String(500, fmt.Sprintf("Failed to cancel job: %v", err))
+	}
+	return c.NoContent(204)
+}
+
+// Send the template as a server-sent event
+func sendTemplate(c echo.Context, tmpls *template.Template, name string, data IndexPage) {
+	var msgBuffer bytes.Buffer
+	var msg string
+	err := tmpls.ExecuteTemplate(&msgBuffer, name, data)
+	if err != nil {
+		msg = fmt.Sprintf("Failed to read values: %+v", err)
+	} else {
+		msg = strings.ReplaceAll(msgBuffer.String(), "\n", "")
+	}
+	fmt.Fprintf(c.Response().Writer, "data: %s\n\n", msg)
+}
+
+// Fetch the ranks (every node if top is 0) with the outlinks of the nodes
+// (the number of nodes of the graph is also returned)
+func fetchRanks(ctx context.Context, client proto.APIClient, job string, top int32) (*proto.Ranks, int32, error) {
+	ranks := &proto.Ranks{
+		Job:    job,
+		Ranks:  make(map[int32]float64),
+		Labels: make(map[int32]string),
+	}
+	// The edges of the top nodes are sent with them, every edge is fetched in pages
+	request := &proto.RanksRequest{Job: job, Top: top, Order: proto.RanksOrder_ORDER_ID, Limit: 1000, Edges: top > 0}
+	for {
+		page, err := client.GetRanks(ctx, request)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, node := range page.Nodes {
+			ranks.Ranks[node.Id] = node.Rank
+			if node.Label != strconv.FormatInt(int64(node.Id), 10) {
+				ranks.Labels[node.Id] = node.Label
 			}
-			err := tmpls.ExecuteTemplate(&msgBuffer, "ranks", IndexPage{
-				Values:    values.Ranks,
-				Master:    values.Master,
-				Status:    values.Status,
-				Dot:       values.DotGraph,
-				Base64Dot: base64Dot,
-			})
-			if err != nil {
-				msg = fmt.Sprintf("Failed to read values: %+v", err)
-			} else {
-				msg = strings.ReplaceAll(msgBuffer.String(), "\n", "")
+		}
+		ranks.Edges = append(ranks.Edges, page.Edges...)
+		request.Offset += int32(len(page.Nodes))
+		if top > 0 || len(page.Nodes) == 0 || request.Offset >= page.Total {
+			if top == 0 {
+				ranks.Edges, err = fetchEdges(ctx, client, job)
+				if err != nil {
+					return nil, 0, err
+				}
 			}
-			fmt.Fprintf(c.Response().Writer, "data: %s\n\n", msg)
-			return nil
+			return ranks, page.Total, nil
+		}
+	}
+}
+
+// Fetch every edge of the graph of the job (in pages)
+func fetchEdges(ctx context.Context, client proto.APIClient, job string) ([]int32, error) {
+	var edges []int32
+	request := &proto.EdgesRequest{Job: job, Limit: 50000}
+	for {
+		page, err := client.GetEdges(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, page.Edges...)
+		request.Offset += int32(len(page.Edges) / 2)
+		if len(page.Edges) == 0 || request.Offset >= page.Total {
+			return edges, nil
 		}
 	}
 }
 
-func sseRender(c echo.Context) error {
+// Download the results of a job (CSV, JSON, GraphML or GEXF)
+func export(c echo.Context, jobs *Jobs) error {
+	job := c.Param("job")
+	exporter, err := graph.GetExporter(c.Param("format"))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	api, err := jobs.api(job)
+	if err != nil {
+		return c.String(404, err.Error())
+	}
+	defer api.Close()
+	// The ranks are fetched in pages (no time limit)
+	ranks, _, err := fetchRanks(c.Request().Context(), api.Client, job, 0)
+	if err != nil {
+		return c.String(500, fmt.Sprintf("Failed to get ranks: %v", err))
+	}
+	c.Response().Header().Set("Content-Type", exporter.ContentType)
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s\"", job, exporter.Extension))
+	return exporter.Write(c.Response().Writer, ranks)
+}
+
+// Render the top nodes of the job results (and the edges between them)
+func sseRender(c echo.Context, tmpls *template.Template, jobs *Jobs) error {
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
-	base64Dot := c.Param("dot")
-	if base64Dot == "dot" {
-		fmt.Fprintf(c.Response().Writer, "data: <p>Failed to render</p>\n\n")
+	job := c.Param("job")
+	api, err := jobs.api(job)
+	if err != nil {
+		fmt.Fprintf(c.Response().Writer, "data: <p>Failed to render: %v</p>\n\n", err)
 		return nil
 	}
-	dot, err := base64.StdEncoding.DecodeString(base64Dot)
+	defer api.Close()
+	ranks, total, err := fetchRanks(api.Ctx, api.Client, job, renderNodes)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to decode DOT Graph: %+v", err)
+		fmt.Fprintf(c.Response().Writer, "data: <p>Failed to render: %v</p>\n\n", err)
+		return nil
+	}
+	var dot bytes.Buffer
+	if err := graph.WriteDot(&dot, ranks, 0); err != nil {
+		msg := fmt.Sprintf("Failed to write DOT Graph: %+v", err)
 		fmt.Fprintf(c.Response().Writer, "data: %s\n\n", msg)
 		return nil
 	}
-	svg := convertToSvg(string(dot))
-	fmt.Fprintf(c.Response().Writer, "data: %s\n\n", svg)
+	data := IndexPage{
+		Job: job,
+		Dot: dot.String(),
+		Svg: template.HTML(convertToSvg(dot.String())),
+	}
+	if int(total) > len(ranks.Ranks) {
+		data.Status = fmt.Sprintf("Top %d nodes of %d", len(ranks.Ranks), total)
+	}
+	sendTemplate(c, tmpls, "render", data)
 	return nil
 }
 
-func newRanks(ctx echo.Context, connection string) error {
+func newRanks(ctx echo.Context, jobs *Jobs) error {
 	apiUrl := ctx.FormValue("api")
 	cStr := ctx.FormValue("c")
 	thresholdStr := ctx.FormValue("threshold")
 	graph := ctx.FormValue("graph")
+	graphFile, _ := ctx.FormFile("graphFile")
+	maxInvalidLinesStr := ctx.FormValue("maxInvalidLines")
+	weighted := ctx.FormValue("weighted") == "true"
+	format := ctx.FormValue("format")
+	maxInvalidLines := 0
 	numNodesStr := ctx.FormValue("numNodes")
 	numNodes := 30
 	numEdgesStr := ctx.FormValue("numEdges")
 	numEdges := 5
+	seedStr := ctx.FormValue("seed")
+	var seed *int64
+	modelStr := ctx.FormValue("model")
+	modelParams := ctx.FormValue("modelParams")
+	partitioningStr := ctx.FormValue("partitioning")
+	danglingStr := ctx.FormValue("dangling")
+	eModeStr := ctx.FormValue("eMode")
+	eStr := ctx.FormValue("e")
 
 	errors := make(map[string]string)
 
@@ -183,9 +484,32 @@ func newRanks(ctx echo.Context, connection string) error {
 	if err != nil {
 		errors["threshold"] = "Failed to parse as a number"
 	}
+	partitioning, ok := proto.Partitioning_value[partitioningStr]
+	if partitioningStr != "" && !ok {
+		errors["partitioning"] = "Unknown partitioning strategy"
+	}
+	dangling, ok := proto.Dangling_value[danglingStr]
+	if danglingStr != "" && !ok {
+		errors["dangling"] = "Unknown dangling nodes strategy"
+	}
+	e, err := parseE(eModeStr, eStr)
+	if err != nil {
+		errors["e"] = err.Error()
+	}
 	if graph != "" && !strings.HasPrefix(graph, "http") {
 		errors["graph"] = "Invalid Graph Resource"
 	}
+	if graph != "" && graphFile != nil {
+		errors["graph"] = "Provide either a Graph URL or a Graph file"
+	}
+	if maxInvalidLinesStr != "" {
+		num, err := strconv.Atoi(maxInvalidLinesStr)
+		if err != nil || num < 0 {
+			errors["maxInvalidLines"] = "Failed to parse as a positive number"
+		} else {
+			maxInvalidLines = num
+		}
+	}
 	if numNodesStr != "" {
 		num, err := strconv.Atoi(numNodesStr)
 		if err != nil {
@@ -198,25 +522,43 @@ func newRanks(ctx echo.Context, connection string) error {
 	if err == nil && numEdgesTemp >= 3 {
 		numEdges = numEdgesTemp
 	}
+	if seedStr != "" {
+		num, err := strconv.ParseInt(seedStr, 10, 64)
+		if err != nil {
+			errors["seed"] = "Failed to parse as a number"
+		} else {
+			seed = &num
+		}
+	}
+	randomGraph := &proto.RandomGraph{
+		NumberOfNodes:    int32(numNodes),
+		MaxNumberOfEdges: int32(numEdges),
+		Seed:             seed,
+	}
+	if err := parseModel(randomGraph, modelStr, modelParams); err != nil {
+		errors["model"] = err.Error()
+	}
 
 	if len(errors) > 0 {
 		return ctx.Render(200, "ranks.new", IndexPage{FormErrors: errors})
 	}
 
 	configuration := proto.Configuration{
-		C:          c,
-		Threshold:  threshold,
-		Connection: connection,
+		C:               c,
+		Threshold:       threshold,
+		Partitioning:    proto.Partitioning(partitioning),
+		Dangling:        proto.Dangling(dangling),
+		E:               e,
+		MaxInvalidLines: int32(maxInvalidLines),
+		Weighted:        weighted,
+		Format:          format,
 	}
 
 	if graph != "" {
 		configuration.Value = &proto.Configuration_Graph{Graph: graph}
 	} else {
 		configuration.Value = &proto.Configuration_RandomGraph{
-			RandomGraph: &proto.RandomGraph{
-				NumberOfNodes:    int32(numNodes),
-				MaxNumberOfEdges: int32(numEdges),
-			},
+			RandomGraph: randomGraph,
 		}
 	}
 
@@ -226,16 +568,163 @@ func newRanks(ctx echo.Context, connection string) error {
 			Error: fmt.Sprintf("Failed to contact API: %v", err),
 		})
 	}
-	_, err = api.Client.GraphUpload(api.Ctx, &configuration)
+	defer api.Close()
+	var job *wrapperspb.StringValue
+	if graphFile != nil {
+		// The upload is not limited by the API call timeout
+		job, err = uploadGraph(ctx.Request().Context(), api.Client, &configuration, graphFile)
+	} else {
+		job, err = api.Client.GraphUpload(api.Ctx, &configuration)
+	}
 	if err != nil {
 		return ctx.Render(200, "ranks.new", IndexPage{
 			Error: fmt.Sprintf("Failed to call API: %v", err),
 		})
 	}
+	log.Printf("Submitted job %s", job.Value)
+	jobs.mu.Lock()
+	jobs.apis[job.Value] = apiUrl
+	jobs.mu.Unlock()
 
 	return ctx.Render(200, "status", IndexPage{
-		Status: "Calculating...",
+		Job:       job.Value,
+		Iteration: -1,
+		Status:    "0",
+	})
+}
+
+// Send the graph file in chunks (the file name is used to detect the format)
+func uploadGraph(ctx context.Context, client proto.APIClient, configuration *proto.Configuration, header *multipart.FileHeader) (*wrapperspb.StringValue, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stream, err := client.UploadGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configuration.Value = &proto.Configuration_Graph{Graph: header.Filename}
+	err = stream.Send(&proto.GraphChunk{
+		Value: &proto.GraphChunk_Configuration{Configuration: configuration},
 	})
+	if err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			err := stream.Send(&proto.GraphChunk{
+				Value: &proto.GraphChunk_Data{Data: buffer[:n]},
+			})
+			if err == io.EOF {
+				// The server stopped reading: the error is returned by CloseAndRecv
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// Parse the E vector form values (the meaning of value depends on the mode)
+func parseE(modeStr, value string) (*proto.Personalization, error) {
+	mode, ok := proto.EMode_value[modeStr]
+	if modeStr != "" && !ok {
+		return nil, fmt.Errorf("Unknown E mode")
+	}
+	e := &proto.Personalization{Mode: proto.EMode(mode)}
+	var tokens []string
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	switch e.Mode {
+	case proto.EMode_E_RANDOM:
+		if len(tokens) != 1 {
+			return nil, fmt.Errorf("Expecting a seed")
+		}
+		seed, err := strconv.ParseInt(tokens[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse seed as a number")
+		}
+		e.Seed = &seed
+	case proto.EMode_E_EXPLICIT:
+		e.Weights = make(map[string]float64)
+		for _, token := range tokens {
+			// The label can contain ':' (the weight is after the last one)
+			i := strings.LastIndex(token, ":")
+			if i < 0 || strings.TrimSpace(token[:i]) == "" {
+				return nil, fmt.Errorf("Expecting node:weight, found %s", token)
+			}
+			weight, err := strconv.ParseFloat(strings.TrimSpace(token[i+1:]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse weight %s", token[i+1:])
+			}
+			e.Weights[strings.TrimSpace(token[:i])] = weight
+		}
+	case proto.EMode_E_SEED_SET:
+		e.Nodes = tokens
+	}
+	return e, nil
+}
+
+// Parse the random graph model form values (comma separated parameters)
+func parseModel(randomGraph *proto.RandomGraph, model, value string) error {
+	var params []float64
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token == "" {
+			continue
+		}
+		param, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return fmt.Errorf("Failed to parse parameter %s", token)
+		}
+		params = append(params, param)
+	}
+	expected := map[string]int{"": 0, "erdosRenyi": 1, "barabasiAlbert": 1, "wattsStrogatz": 2, "rmat": 4}
+	count, ok := expected[model]
+	if !ok {
+		return fmt.Errorf("Unknown graph model")
+	}
+	if len(params) != count {
+		return fmt.Errorf("Expecting %d parameters, found %d", count, len(params))
+	}
+	// The first parameter of these models is an integer (M, K, RMat edges)
+	if model == "barabasiAlbert" || model == "wattsStrogatz" || model == "rmat" {
+		if params[0] != math.Trunc(params[0]) || params[0] < 0 || params[0] > math.MaxInt32 {
+			return fmt.Errorf("Expecting an integer parameter, found %v", params[0])
+		}
+	}
+	switch model {
+	case "erdosRenyi":
+		randomGraph.Model = &proto.RandomGraph_ErdosRenyi{
+			ErdosRenyi: &proto.ErdosRenyi{P: params[0]},
+		}
+	case "barabasiAlbert":
+		randomGraph.Model = &proto.RandomGraph_BarabasiAlbert{
+			BarabasiAlbert: &proto.BarabasiAlbert{M: int32(params[0])},
+		}
+	case "wattsStrogatz":
+		randomGraph.Model = &proto.RandomGraph_WattsStrogatz{
+			WattsStrogatz: &proto.WattsStrogatz{K: int32(params[0]), Beta: params[1]},
+		}
+	case "rmat":
+		randomGraph.Model = &proto.RandomGraph_Rmat{
+			Rmat: &proto.RMat{Edges: int32(params[0]), A: params[1], B: params[2], C: params[3]},
+		}
+	}
+	return nil
 }
 
 func convertToSvg(dotGraph string) string {
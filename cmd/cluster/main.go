@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/joho/godotenv"
+	"github.com/lioia/distributed-pagerank/pkg/node"
+	"github.com/lioia/distributed-pagerank/pkg/utils"
+	"github.com/lioia/distributed-pagerank/proto"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Run a master and WORKERS worker nodes in this process, exchanging jobs
+// through an in-memory broker or gRPC streams (no RabbitMQ required)
+func main() {
+	_ = godotenv.Load()
+	host := utils.ReadStringEnvVarOr("HOST", "localhost")
+	workers := utils.ReadIntEnvVarOr("WORKERS", 2)
+	streams := utils.ReadStringEnvVarOr("BROKER", "memory") == "grpc"
+	utils.InitLog(
+		utils.ReadBoolEnvVarOr("NODE_LOG", false),
+		utils.ReadBoolEnvVarOr("SERVER_LOG", false),
+	)
+
+	var broker node.Broker
+	if !streams {
+		// Every node shares the same queues
+		broker = node.NewMemoryBroker(1024)
+	}
+	master := newNode(host, broker)
+	fmt.Printf("Starting Master node at %s\n", master.Connection)
+	for i := 0; i < workers; i++ {
+		worker := newNode(host, broker)
+		_, err := worker.Join(master.Connection)
+		utils.FailOnError("Failed to join master node", err)
+		fmt.Printf("Starting Worker node at %s\n", worker.Connection)
+		go worker.Update()
+	}
+	master.Update()
+}
+
+// Create a node listening on a random port, serving internal communication
+// (nil broker: the node uses gRPC streams)
+func newNode(host string, broker node.Broker) *node.Node {
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:0", host))
+	utils.FailOnError("Failed to listen for node server", err)
+	id, _ := gonanoid.New()
+	n := &node.Node{
+		Id: id,
+		State: &proto.State{
+			Others: make(map[string]string),
+		},
+		Role:       node.Master,
+		Connection: fmt.Sprintf("%s:%d", host, lis.Addr().(*net.TCPAddr).Port),
+		Broker:     broker,
+	}
+	if broker == nil {
+		n.Broker = node.NewStreamBroker(n)
+	}
+	n.StartServer(lis)
+	return n
+}
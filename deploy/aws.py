@@ -89,7 +89,6 @@ public_workers_hosts = data["dp-workers-hosts-public"]["value"]
 private_workers_hosts = data["dp-workers-hosts-private"]["value"]
 
 public_client_host = data["dp-client-host-public"]["value"]
-private_client_host = data["dp-client-host-private"]["value"]
 
 threads: list[threading.Thread] = []
 
@@ -113,8 +112,6 @@ client_service = f'''[Unit]
 Description=distributed-pagerank application
 
 [Service]
-Environment=HOST={private_client_host}
-Environment=RPC_PORT={config['grpc_port']}
 Type=simple
 WorkingDirectory=/home/ec2-user/dp
 ExecStart=/home/ec2-user/dp/build/client
@@ -19,6 +19,9 @@ def worker_node(id, config):
       - "API_PORT={config['api_port']}"
       - "NODE_LOG={config['node_log']}"
       - "SERVER_LOG={config['server_log']}"
+      - "STORE_DIR=/store"
+    volumes:
+      - store:/store # shared: an elected master resumes the checkpointed jobs
 """
 
 config_file = open("config.json")
@@ -31,9 +34,6 @@ compose_str = f"""services:
       dockerfile: deploy/Dockerfile.client
     ports:
       - 80:80
-    environment:
-      - "HOST=client"
-      - "RPC_PORT={config['grpc_port']}"
   rabbitmq:
     image: rabbitmq:management-alpine
     ports:
@@ -64,6 +64,9 @@ compose_str = f"""services:
       - "HEALTH_CHECK={config['health_check']}"
       - "NODE_LOG={config['node_log']}"
       - "SERVER_LOG={config['server_log']}"
+      - "STORE_DIR=/store"
+    volumes:
+      - store:/store # completed jobs and checkpoints, shared with the workers
     healthcheck:
       test: [ "CMD", "nc", "-z", "-w3", "localhost", "{config['grpc_port']}" ]
       interval: 10s
@@ -77,6 +80,11 @@ for i in range(config['workers']):
   {worker}
     """
 
+compose_str = f"""{compose_str}
+volumes:
+  store:
+"""
+
 compose_file = open("compose.yaml", "w")
 compose_file.write(compose_str)
 compose_file.close()
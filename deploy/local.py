@@ -6,7 +6,6 @@ config = json.load(config_file)
 base_env = f"""HOST=localhost
 RABBIT_HOST=localhost
 API_PORT={config['api_port']}
-RPC_PORT={config['grpc_port']}
 PORT={config['grpc_port']+1}
 MASTER=localhost:{config['grpc_port']+1}
 HEALTH_CHECK={config['health_check']}
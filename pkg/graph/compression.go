@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Decompress r based on the extension of the resource or on the
+// Content-Type (empty for local files); other inputs are returned as is
+func decompress(r io.Reader, resource, contentType string) (io.ReadCloser, error) {
+	switch compression(resource, contentType) {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+func compression(resource, contentType string) string {
+	// Query parameters are not part of the file name
+	if u, err := url.Parse(resource); err == nil && u.Scheme != "" {
+		resource = u.Path
+	}
+	switch strings.ToLower(path.Ext(resource)) {
+	case ".gz", ".gzip":
+		return "gzip"
+	case ".bz2":
+		return "bzip2"
+	case ".zst", ".zstd":
+		return "zstd"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/gzip", "application/x-gzip":
+		return "gzip"
+	case "application/x-bzip2":
+		return "bzip2"
+	case "application/zstd":
+		return "zstd"
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package graph
+
+import (
+	"sort"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Compressed sparse row representation used for computation:
+// nodes are identified by a dense index (position in Ids)
+type CSR struct {
+	Ids     []int32         // Dense index -> node ID
+	Index   map[int32]int32 // Node ID -> dense index
+	Nodes   int             // Number of nodes whose in-links are stored (the first ones)
+	InStart []int32         // In-links of node i are InLinks[InStart[i]:InStart[i+1]]
+	InLinks []int32         // Dense index of the source of every in-link
+	Weight  []float64       // Transition probability of every in-link
+	Degree  []int32         // Number of outlinks of every node (in the whole graph)
+	Rank    []float64       // Current PageRank
+	E       []float64       // E probability vector
+}
+
+// Convert the whole graph
+func NewCSR(graph map[int32]*proto.GraphNode) *CSR {
+	ids := sortedIds(graph)
+	g := newCSR(ids, len(ids))
+	for i, id := range ids {
+		g.Rank[i] = graph[id].Rank
+		g.E[i] = graph[id].E
+		g.addInLinks(i, graph[id].InLinks)
+	}
+	for _, j := range g.InLinks {
+		g.Degree[j] += 1
+	}
+	return g
+}
+
+// Convert a partition (in-links of some nodes): the sources of the in-links
+// are stored after the partition nodes, with their outlinks in the whole graph
+func NewPartitionCSR(partition map[int32]*proto.Map) *CSR {
+	nodes := make([]int32, 0, len(partition))
+	for id := range partition {
+		nodes = append(nodes, id)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	// Sources outside of the partition
+	inPartition := make(map[int32]bool, len(nodes))
+	for _, id := range nodes {
+		inPartition[id] = true
+	}
+	var sources []int32
+	for _, id := range nodes {
+		for j := range partition[id].InLinks {
+			if !inPartition[j] {
+				inPartition[j] = true
+				sources = append(sources, j)
+			}
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	g := newCSR(append(nodes, sources...), len(nodes))
+	for i, id := range nodes {
+		g.addInLinks(i, partition[id].InLinks)
+		for j, v := range partition[id].InLinks {
+			g.Degree[g.Index[j]] = v.Outlinks
+		}
+	}
+	return g
+}
+
+// Copy the ranks back in the proto representation (nodes and in-links)
+func (g *CSR) StoreRanks(graph map[int32]*proto.GraphNode) {
+	for i, id := range g.Ids {
+		if node, ok := graph[id]; ok {
+			node.Rank = g.Rank[i]
+		}
+	}
+	for _, node := range graph {
+		for j, v := range node.InLinks {
+			v.Rank = g.Rank[g.Index[j]]
+		}
+	}
+}
+
+func newCSR(ids []int32, nodes int) *CSR {
+	g := &CSR{
+		Ids:     ids,
+		Index:   make(map[int32]int32, len(ids)),
+		Nodes:   nodes,
+		InStart: make([]int32, nodes+1),
+		Degree:  make([]int32, len(ids)),
+		Rank:    make([]float64, len(ids)),
+		E:       make([]float64, len(ids)),
+	}
+	for i, id := range ids {
+		g.Index[id] = int32(i)
+	}
+	return g
+}
+
+// Append the in-links of node i (nodes have to be added in order)
+func (g *CSR) addInLinks(i int, inLinks map[int32]*proto.GraphNodeInfo) {
+	start := len(g.InLinks)
+	for j := range inLinks {
+		g.InLinks = append(g.InLinks, g.Index[j])
+	}
+	sources := g.InLinks[start:]
+	sort.Slice(sources, func(a, b int) bool { return sources[a] < sources[b] })
+	for _, j := range sources {
+		g.Weight = append(g.Weight, inLinks[g.Ids[j]].Probability)
+	}
+	g.InStart[i+1] = int32(len(g.InLinks))
+}
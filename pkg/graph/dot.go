@@ -0,0 +1,59 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Write the graph in DOT format: size and color of the nodes scale with the
+// rank; if top > 0, only the top nodes by rank (and the edges between them)
+// are written
+func WriteDot(w io.Writer, ranks *proto.Ranks, top int) error {
+	ids := SortByRank(ranks.Ranks)
+	if top > 0 && top < len(ids) {
+		ids = ids[:top]
+	}
+	max := 0.0
+	if len(ids) > 0 {
+		max = ranks.Ranks[ids[0]]
+	}
+	selected := make(map[int32]bool, len(ids))
+	writer := bufio.NewWriter(w)
+	writer.WriteString("digraph {\n")
+	writer.WriteString("  node [shape=circle, style=filled, fixedsize=true];\n")
+	for _, id := range ids {
+		selected[id] = true
+		rank := ranks.Ranks[id]
+		// Relative rank (0: lowest possible, 1: highest rank)
+		t := 0.0
+		if max > 0 {
+			t = rank / max
+		}
+		fmt.Fprintf(writer, "  %d [label=\"%s\\n%.4f\", width=%.2f, fontsize=%.1f, fillcolor=\"0.600 %.3f 0.950\", fontcolor=\"%s\"];\n",
+			id, escapeDot(Label(ranks, id)), rank, 0.6+1.4*t, 8+8*t, 0.1+0.9*t, fontColor(t))
+	}
+	for i := 0; i+1 < len(ranks.Edges); i += 2 {
+		from, to := ranks.Edges[i], ranks.Edges[i+1]
+		if selected[from] && selected[to] {
+			fmt.Fprintf(writer, "  %d -> %d;\n", from, to)
+		}
+	}
+	writer.WriteString("}\n")
+	return writer.Flush()
+}
+
+// Readable font on dark nodes
+func fontColor(t float64) string {
+	if t > 0.6 {
+		return "white"
+	}
+	return "black"
+}
+
+func escapeDot(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
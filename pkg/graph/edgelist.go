@@ -0,0 +1,101 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Edge list reader: one edge per line (FromNode ToNode [Weight]), with any
+// whitespace or comma as separator; the weight is only read if Weighted
+// (optional on every line, default 1) and extra columns are ignored
+// Nodes are labels (converted to IDs by the loader)
+// Lines starting with #, // or % are comments
+type EdgeListParser struct {
+	MaxInvalid int  // Invalid lines skipped before failing (0: fail on the first one)
+	Weighted   bool // The third column is the edge weight (otherwise every edge has weight 1)
+	Line       int  // Number of the last parsed line (starting from 1)
+	Invalid    int  // Invalid lines skipped so far
+}
+
+// Edge list file format (EdgeListParser)
+type EdgeListFormat struct{}
+
+func (EdgeListFormat) Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error {
+	parser := EdgeListParser{
+		MaxInvalid: options.MaxInvalidLines,
+		Weighted:   options.Weighted,
+	}
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		edge, ok, err := parser.Parse(scanner.Text())
+		// There was an error loading the line
+		if err != nil {
+			return err
+		}
+		// Comment line -> no new node to add
+		if !ok {
+			continue
+		}
+		if err := b.AddEdge(edge); err != nil {
+			return fmt.Errorf("Line %d: %v", parser.Line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Line %d: %v", parser.Line+1, err)
+	}
+	return nil
+}
+
+type Edge struct {
+	From   string  // Label of the source node
+	To     string  // Label of the destination node
+	Weight float64 // Edge weight (positive)
+}
+
+// Parse the next line; ok is false if there is no edge in the line
+// (comment, empty or skipped line)
+func (p *EdgeListParser) Parse(line string) (edge Edge, ok bool, err error) {
+	p.Line += 1
+	edge, ok, err = parseEdge(line, p.Weighted)
+	if err == nil {
+		return edge, ok, nil
+	}
+	err = fmt.Errorf("Line %d: %v", p.Line, err)
+	if p.Invalid >= p.MaxInvalid {
+		if p.MaxInvalid > 0 {
+			return Edge{}, false, fmt.Errorf("Too many invalid lines (%d). %v", p.Invalid+1, err)
+		}
+		return Edge{}, false, err
+	}
+	p.Invalid += 1
+	log.Printf("Skipping invalid line: %v", err)
+	return Edge{}, false, nil
+}
+
+func parseEdge(line string, weighted bool) (Edge, bool, error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "\uFEFF"))
+	// Skip comment and empty lines
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "%") {
+		return Edge{}, false, nil
+	}
+	tokens := strings.FieldsFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	if len(tokens) < 2 {
+		return Edge{}, false, fmt.Errorf("Expecting FromNode and ToNode, found %q", line)
+	}
+	edge := Edge{From: tokens[0], To: tokens[1], Weight: 1}
+	if weighted && len(tokens) >= 3 {
+		weight, err := strconv.ParseFloat(tokens[2], 64)
+		if err != nil || !(weight > 0) || math.IsInf(weight, 0) {
+			return Edge{}, false, fmt.Errorf("Invalid Weight %q (expecting a positive number)", tokens[2])
+		}
+		edge.Weight = weight
+	}
+	return edge, true, nil
+}
@@ -0,0 +1,126 @@
+package graph
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEdgeListParser(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string
+		maxInvalid int
+		weighted   bool
+		edges      []Edge // Edges of the valid lines
+		invalid    int    // Skipped lines
+		err        string // Expected error (empty: no error)
+	}{
+		{
+			name:  "comments and separators",
+			lines: []string{"\uFEFF# comment", "// comment", "% comment", "", "1 2", "2,3", "3\t1  x extra"},
+			edges: []Edge{{"1", "2", 1}, {"2", "3", 1}, {"3", "1", 1}},
+		},
+		{
+			name:  "unweighted ignores the extra columns",
+			lines: []string{"1 2 1700000000", "2 3 -1", "3 1 positive"},
+			edges: []Edge{{"1", "2", 1}, {"2", "3", 1}, {"3", "1", 1}},
+		},
+		{
+			name:     "optional weight",
+			lines:    []string{"a b 2.5", "b c", "c a 1e-3 extra"},
+			weighted: true,
+			edges:    []Edge{{"a", "b", 2.5}, {"b", "c", 1}, {"c", "a", 1e-3}},
+		},
+		{
+			name:  "missing node",
+			lines: []string{"1 2", "3"},
+			edges: []Edge{{"1", "2", 1}},
+			err:   `Line 2: Expecting FromNode and ToNode, found "3"`,
+		},
+		{
+			name:     "invalid weight",
+			lines:    []string{"# header", "1 2", "2 3 x"},
+			weighted: true,
+			edges:    []Edge{{"1", "2", 1}},
+			err:      `Line 3: Invalid Weight "x"`,
+		},
+		{
+			name:     "non-positive weight",
+			lines:    []string{"1 2 0"},
+			weighted: true,
+			err:      `Line 1: Invalid Weight "0"`,
+		},
+		{
+			name:     "NaN weight",
+			lines:    []string{"1 2 NaN"},
+			weighted: true,
+			err:      `Line 1: Invalid Weight "NaN"`,
+		},
+		{
+			name:       "invalid lines within the budget",
+			lines:      []string{"1 2", "3", "2 3", "4 5 -1"},
+			maxInvalid: 2,
+			weighted:   true,
+			edges:      []Edge{{"1", "2", 1}, {"2", "3", 1}},
+			invalid:    2,
+		},
+		{
+			name:       "invalid lines over the budget",
+			lines:      []string{"1", "1 2", "2", "3"},
+			maxInvalid: 2,
+			edges:      []Edge{{"1", "2", 1}},
+			invalid:    2,
+			err:        "Too many invalid lines (3). Line 4:",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := EdgeListParser{MaxInvalid: test.maxInvalid, Weighted: test.weighted}
+			var edges []Edge
+			var err error
+			for _, line := range test.lines {
+				var edge Edge
+				var ok bool
+				edge, ok, err = parser.Parse(line)
+				if err != nil {
+					break
+				}
+				if ok {
+					edges = append(edges, edge)
+				}
+			}
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+			if len(edges) != len(test.edges) {
+				t.Fatalf("expected edges %v, got %v", test.edges, edges)
+			}
+			for i := range edges {
+				if edges[i] != test.edges[i] {
+					t.Errorf("edge %d: expected %v, got %v", i, test.edges[i], edges[i])
+				}
+			}
+			if parser.Invalid != test.invalid {
+				t.Errorf("expected %d invalid lines, got %d", test.invalid, parser.Invalid)
+			}
+		})
+	}
+}
+
+func TestEdgeListLineNumbers(t *testing.T) {
+	input := "1 2\n\n# comment\n2 3\nbad\n"
+	_, _, err := LoadGraph(strings.NewReader(input), LoadOptions{Format: "edgelist"})
+	if err == nil || !strings.Contains(err.Error(), "Line 5:") {
+		t.Fatalf("expected error at line 5, got %v", err)
+	}
+	g, _, err := LoadGraph(strings.NewReader(input), LoadOptions{Format: "edgelist", MaxInvalidLines: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g) != 3 {
+		t.Errorf("expected 3 nodes, got %d", len(g))
+	}
+}
@@ -0,0 +1,167 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Result file format
+type Exporter struct {
+	Extension   string
+	ContentType string
+	Write       func(w io.Writer, ranks *proto.Ranks) error
+}
+
+var exporters = map[string]Exporter{
+	"csv":     {Extension: ".csv", ContentType: "text/csv", Write: WriteCSV},
+	"json":    {Extension: ".json", ContentType: "application/json", Write: WriteJSON},
+	"graphml": {Extension: ".graphml", ContentType: "application/xml", Write: WriteGraphML},
+	"gexf":    {Extension: ".gexf", ContentType: "application/xml", Write: WriteGEXF},
+}
+
+// Names of the export formats (sorted)
+func ExportFormats() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetExporter(name string) (Exporter, error) {
+	exporter, ok := exporters[name]
+	if !ok {
+		return Exporter{}, fmt.Errorf("Unknown export format %s (available: %s)", name, strings.Join(ExportFormats(), ", "))
+	}
+	return exporter, nil
+}
+
+// Node IDs sorted by rank (highest first, ties by ID)
+func SortByRank(ranks map[int32]float64) []int32 {
+	ids := make([]int32, 0, len(ranks))
+	for id := range ranks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ranks[ids[i]] != ranks[ids[j]] {
+			return ranks[ids[i]] > ranks[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Node IDs sorted by ID
+func SortByID(ranks map[int32]float64) []int32 {
+	ids := make([]int32, 0, len(ranks))
+	for id := range ranks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Label of the node (the ID if the nodes are integers)
+func Label(ranks *proto.Ranks, id int32) string {
+	if label, ok := ranks.Labels[id]; ok {
+		return label
+	}
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// CSV: id, label, rank (sorted by rank)
+func WriteCSV(w io.Writer, ranks *proto.Ranks) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "label", "rank"}); err != nil {
+		return err
+	}
+	for _, id := range SortByRank(ranks.Ranks) {
+		record := []string{
+			strconv.FormatInt(int64(id), 10),
+			Label(ranks, id),
+			strconv.FormatFloat(ranks.Ranks[id], 'g', -1, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type jsonRank struct {
+	Id    int32   `json:"id"`
+	Label string  `json:"label"`
+	Rank  float64 `json:"rank"`
+}
+
+// JSON: list of {id, label, rank} (sorted by rank)
+func WriteJSON(w io.Writer, ranks *proto.Ranks) error {
+	values := make([]jsonRank, 0, len(ranks.Ranks))
+	for _, id := range SortByRank(ranks.Ranks) {
+		values = append(values, jsonRank{Id: id, Label: Label(ranks, id), Rank: ranks.Ranks[id]})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
+
+// GraphML: label and rank as node data
+func WriteGraphML(w io.Writer, ranks *proto.Ranks) error {
+	writer := bufio.NewWriter(w)
+	writer.WriteString(xml.Header)
+	writer.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	writer.WriteString("  <key id=\"label\" for=\"node\" attr.name=\"label\" attr.type=\"string\"/>\n")
+	writer.WriteString("  <key id=\"rank\" for=\"node\" attr.name=\"rank\" attr.type=\"double\"/>\n")
+	writer.WriteString("  <graph edgedefault=\"directed\">\n")
+	for _, id := range SortByID(ranks.Ranks) {
+		fmt.Fprintf(writer, "    <node id=\"%d\"><data key=\"label\">%s</data><data key=\"rank\">%s</data></node>\n",
+			id, escape(Label(ranks, id)), strconv.FormatFloat(ranks.Ranks[id], 'g', -1, 64))
+	}
+	for i := 0; i+1 < len(ranks.Edges); i += 2 {
+		fmt.Fprintf(writer, "    <edge source=\"%d\" target=\"%d\"/>\n", ranks.Edges[i], ranks.Edges[i+1])
+	}
+	writer.WriteString("  </graph>\n</graphml>\n")
+	return writer.Flush()
+}
+
+// GEXF (Gephi): rank as node attribute
+func WriteGEXF(w io.Writer, ranks *proto.Ranks) error {
+	writer := bufio.NewWriter(w)
+	writer.WriteString(xml.Header)
+	writer.WriteString("<gexf xmlns=\"http://www.gexf.net/1.2draft\" version=\"1.2\">\n")
+	writer.WriteString("  <graph mode=\"static\" defaultedgetype=\"directed\">\n")
+	writer.WriteString("    <attributes class=\"node\">\n")
+	writer.WriteString("      <attribute id=\"rank\" title=\"rank\" type=\"double\"/>\n")
+	writer.WriteString("    </attributes>\n")
+	writer.WriteString("    <nodes>\n")
+	for _, id := range SortByID(ranks.Ranks) {
+		fmt.Fprintf(writer, "      <node id=\"%d\" label=\"%s\"><attvalues><attvalue for=\"rank\" value=\"%s\"/></attvalues></node>\n",
+			id, escape(Label(ranks, id)), strconv.FormatFloat(ranks.Ranks[id], 'g', -1, 64))
+	}
+	writer.WriteString("    </nodes>\n")
+	writer.WriteString("    <edges>\n")
+	for i := 0; i+1 < len(ranks.Edges); i += 2 {
+		fmt.Fprintf(writer, "      <edge id=\"%d\" source=\"%d\" target=\"%d\"/>\n", i/2, ranks.Edges[i], ranks.Edges[i+1])
+	}
+	writer.WriteString("    </edges>\n")
+	writer.WriteString("  </graph>\n</gexf>\n")
+	return writer.Flush()
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Graph file format: nodes and edges are added to the builder while reading
+type Format interface {
+	Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error
+}
+
+type registeredFormat struct {
+	format Format
+	sniff  func(head []byte) bool // Whether the start of the file is in this format (can be nil)
+}
+
+var (
+	formats    = make(map[string]registeredFormat)
+	extensions = make(map[string]string) // Extension -> format name
+)
+
+func init() {
+	RegisterFormat("edgelist", EdgeListFormat{}, nil, ".txt", ".tsv", ".csv", ".el", ".edges")
+	RegisterFormat("mtx", MatrixMarketFormat{}, sniffMatrixMarket, ".mtx")
+	RegisterFormat("graphml", GraphMLFormat{}, sniffGraphML, ".graphml", ".xml")
+	RegisterFormat("json", JSONFormat{}, sniffJSON, ".json")
+	RegisterFormat("metis", MetisFormat{}, nil, ".metis", ".graph")
+}
+
+// Add a format, selected by name, by one of the extensions or by sniff
+func RegisterFormat(name string, format Format, sniff func(head []byte) bool, exts ...string) {
+	formats[name] = registeredFormat{format: format, sniff: sniff}
+	for _, ext := range exts {
+		extensions[ext] = name
+	}
+}
+
+// Names of the registered formats (sorted)
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Format of the file: the explicit one, the one of the extension of the
+// resource (ignoring the compression extension) or the one of the content
+// (edge list if no other format matches)
+func detectFormat(r *bufio.Reader, options LoadOptions) (string, Format, error) {
+	if options.Format != "" {
+		f, ok := formats[options.Format]
+		if !ok {
+			return "", nil, fmt.Errorf("Unknown format %s (available: %s)", options.Format, strings.Join(Formats(), ", "))
+		}
+		return options.Format, f.format, nil
+	}
+	name := options.Resource
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+	if compression(name, "") != "" {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	if format, ok := extensions[strings.ToLower(path.Ext(name))]; ok {
+		return format, formats[format].format, nil
+	}
+	// Peek does not consume the input
+	head, _ := r.Peek(512)
+	for _, format := range Formats() {
+		if f := formats[format]; f.sniff != nil && f.sniff(head) {
+			return format, f.format, nil
+		}
+	}
+	return "edgelist", formats["edgelist"].format, nil
+}
+
+func sniffMatrixMarket(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("%%MatrixMarket"))
+}
+
+func sniffGraphML(head []byte) bool {
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\uFEFF")))
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<graphml"))
+}
+
+func sniffJSON(head []byte) bool {
+	head = bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\uFEFF")))
+	return bytes.HasPrefix(head, []byte("{"))
+}
+
+// Line scanner for line based formats (lines up to 1MB)
+func newLineScanner(r *bufio.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+// Builds the graph while the nodes and edges of a graph file are read
+type GraphBuilder struct {
+	weighted   bool // Repeated edges: weights are added (otherwise they are the same edge)
+	graph      map[int32]*proto.GraphNode
+	dictionary *dictionary // Labels of the nodes (nil while they are integers)
+}
+
+func NewGraphBuilder(weighted bool) *GraphBuilder {
+	return &GraphBuilder{weighted: weighted, graph: make(map[int32]*proto.GraphNode)}
+}
+
+// Add a node (even without edges)
+func (b *GraphBuilder) AddNode(label string) error {
+	_, err := b.node(label)
+	return err
+}
+
+func (b *GraphBuilder) AddEdge(edge Edge) error {
+	from, err := b.node(edge.From)
+	if err != nil {
+		return err
+	}
+	integers := b.dictionary == nil
+	to, err := b.node(edge.To)
+	if err != nil {
+		return err
+	}
+	if integers && b.dictionary != nil {
+		// The target is the first label that is not an integer:
+		// the source (read before) has a dense ID now
+		from = b.dictionary.ids[strconv.FormatInt(int64(from), 10)]
+	}
+	if v, ok := b.graph[to].InLinks[from]; ok {
+		if b.weighted {
+			v.Probability += edge.Weight
+		}
+		return nil
+	}
+	// The weight is normalized by initialize
+	b.graph[to].InLinks[from] = &proto.GraphNodeInfo{Probability: edge.Weight}
+	return nil
+}
+
+// ID of the node with the label (added if it is new)
+func (b *GraphBuilder) node(label string) (int32, error) {
+	id, err := b.id(label)
+	if err != nil {
+		return 0, err
+	}
+	if b.graph[id] == nil {
+		b.graph[id] = &proto.GraphNode{
+			InLinks: make(map[int32]*proto.GraphNodeInfo),
+		}
+	}
+	return id, nil
+}
+
+// Graph and labels (nil if the nodes are integers)
+func (b *GraphBuilder) Build() (map[int32]*proto.GraphNode, []string) {
+	// Outlinks, initial ranks and uniform E (it can be replaced with SetE)
+	initialize(b.graph)
+	if b.dictionary == nil {
+		return b.graph, nil
+	}
+	return b.graph, b.dictionary.labels
+}
@@ -0,0 +1,207 @@
+package graph
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		options LoadOptions
+		input   string
+		nodes   int
+		edges   []string
+		labels  bool   // Whether the nodes have labels (not integers)
+		err     string // Expected error (empty: no error)
+	}{
+		{
+			name:    "edge list",
+			options: LoadOptions{Resource: "graph.txt"},
+			input:   "1 2\n2 3\n3 1\n",
+			nodes:   3,
+			edges:   []string{"1->2", "2->3", "3->1"},
+		},
+		{
+			name:    "edge list labels",
+			options: LoadOptions{Resource: "graph.csv"},
+			input:   "1,2\n2,b\nb,1\n",
+			nodes:   3,
+			edges:   []string{"1->2", "2->b", "b->1"},
+			labels:  true,
+		},
+		{
+			name:    "edge list with duplicate integer formats",
+			options: LoadOptions{Resource: "graph.txt"},
+			input:   "01 2\n1 3\n",
+			nodes:   3,
+			edges:   []string{"1->2", "1->3"},
+		},
+		{
+			name:  "matrix market general",
+			input: "%%MatrixMarket matrix coordinate real general\n% comment\n4 4 3\n1 2 1.5\n2 3 0\n3 1 2\n",
+			nodes: 4,
+			edges: []string{"1->2", "3->1"},
+		},
+		{
+			name:  "matrix market symmetric pattern",
+			input: "%%MatrixMarket matrix coordinate pattern symmetric\n3 3 2\n2 1\n3 3\n",
+			nodes: 3,
+			edges: []string{"1->2", "2->1", "3->3"},
+		},
+		{
+			name:  "matrix market invalid row",
+			input: "%%MatrixMarket matrix coordinate pattern general\n2 2 1\n3 1\n",
+			err:   `Line 3: Invalid row "3"`,
+		},
+		{
+			name:  "matrix market missing entries",
+			input: "%%MatrixMarket matrix coordinate pattern general\n2 2 2\n1 2\n",
+			err:   "Invalid mtx file",
+		},
+		{
+			name: "graphml",
+			input: `<?xml version="1.0"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <graph edgedefault="directed">
+    <node id="a"/><node id="b"/><node id="c"/><node id="d"/>
+    <edge source="a" target="b"/>
+    <edge source="b" target="c" directed="false"/>
+  </graph>
+</graphml>`,
+			nodes:  4,
+			edges:  []string{"a->b", "b->c", "c->b"},
+			labels: true,
+		},
+		{
+			name:    "graphml undirected",
+			options: LoadOptions{Resource: "graph.graphml"},
+			input: `<graphml><graph edgedefault="undirected">
+<edge source="1" target="2"/><edge source="2" target="3"/>
+</graph></graphml>`,
+			nodes: 3,
+			edges: []string{"1->2", "2->1", "2->3", "3->2"},
+		},
+		{
+			name:    "graphml edge without target",
+			options: LoadOptions{Format: "graphml"},
+			input:   `<graphml><graph><edge source="1"/></graph></graphml>`,
+			err:     "Edge without source or target",
+		},
+		{
+			name:   "json neighbours",
+			input:  `{"a": ["b", "c"], "b": [1], "c": []}`,
+			nodes:  4,
+			edges:  []string{"a->b", "a->c", "b->1"},
+			labels: true,
+		},
+		{
+			name:  "json weights",
+			input: `{"1": {"2": 3, "3": 1}, "2": {"1": 1}}`,
+			nodes: 3,
+			edges: []string{"1->2", "1->3", "2->1"},
+		},
+		{
+			name:  "json invalid neighbours",
+			input: `{"1": 2}`,
+			err:   "Expecting the neighbours of 1",
+		},
+		{
+			name:    "metis",
+			options: LoadOptions{Resource: "graph.metis"},
+			input:   "% comment\n4 2\n2 3\n1\n1\n\n",
+			nodes:   4,
+			edges:   []string{"1->2", "1->3", "2->1", "3->1"},
+		},
+		{
+			name:    "metis edge weights",
+			options: LoadOptions{Format: "metis"},
+			input:   "3 2 001\n2 5 3 1\n1 5\n1 1\n",
+			nodes:   3,
+			edges:   []string{"1->2", "1->3", "2->1", "3->1"},
+		},
+		{
+			name:    "metis invalid neighbour",
+			options: LoadOptions{Format: "metis"},
+			input:   "2 1\n3\n1\n",
+			err:     `Line 2: Invalid neighbour "3"`,
+		},
+		{
+			name:    "metis infinite weight",
+			options: LoadOptions{Format: "metis", Weighted: true},
+			input:   "2 1 001\n2 Inf\n1 1\n",
+			err:     `Line 2: Invalid Weight "Inf"`,
+		},
+		{
+			name:    "metis NaN weight",
+			options: LoadOptions{Format: "metis", Weighted: true},
+			input:   "2 1 001\n2 NaN\n1 1\n",
+			err:     `Line 2: Invalid Weight "NaN"`,
+		},
+		{
+			name:    "unknown format",
+			options: LoadOptions{Format: "dot"},
+			input:   "1 2\n",
+			err:     "Unknown format dot",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, labels, err := LoadGraph(strings.NewReader(test.input), test.options)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(g) != test.nodes {
+				t.Errorf("expected %d nodes, got %d", test.nodes, len(g))
+			}
+			if (labels != nil) != test.labels {
+				t.Errorf("expected labels %v, got %v", test.labels, labels)
+			}
+			edges := labelledEdges(g, labels)
+			if strings.Join(edges, " ") != strings.Join(test.edges, " ") {
+				t.Errorf("expected edges %v, got %v", test.edges, edges)
+			}
+		})
+	}
+}
+
+func TestFormatsWeighted(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		from  int32
+		to    int32
+		p     float64 // Transition probability of the edge
+	}{
+		{"edge list", "1 2 3\n1 3\n1 2 1\n", 1, 2, 0.8},
+		{"matrix market", "%%MatrixMarket matrix coordinate real general\n3 3 2\n1 2 3\n1 3 1\n", 1, 2, 0.75},
+		{"json", `{"1": {"2": 1, "3": 3}}`, 1, 3, 0.75},
+		{"metis", "3 2 001\n2 1 3 3\n1 1\n1 3\n", 1, 3, 0.75},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := LoadOptions{Weighted: true}
+			if test.name == "metis" {
+				options.Format = "metis"
+			}
+			g, _, err := LoadGraph(strings.NewReader(test.input), options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			edge := g[test.to].InLinks[test.from]
+			if edge == nil {
+				t.Fatalf("missing edge %d -> %d", test.from, test.to)
+			}
+			if math.Abs(edge.Probability-test.p) > 1e-12 {
+				t.Errorf("expected probability %f, got %f", test.p, edge.Probability)
+			}
+		})
+	}
+}
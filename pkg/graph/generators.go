@@ -0,0 +1,225 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Generate a graph with the model of the configuration
+// Random values are generated by r (the same seed gives the same graph)
+func GenerateModel(config *proto.RandomGraph, r *rand.Rand) (map[int32]*proto.GraphNode, error) {
+	n := config.GetNumberOfNodes()
+	// Node IDs are int32 (R-MAT places the nodes in a 2^scale matrix)
+	if n <= 0 || n > 1<<30 {
+		return nil, fmt.Errorf("Invalid number of nodes %d (at most %d)", n, 1<<30)
+	}
+	switch model := config.GetModel().(type) {
+	case *proto.RandomGraph_ErdosRenyi:
+		return ErdosRenyi(n, model.ErdosRenyi.GetP(), r)
+	case *proto.RandomGraph_BarabasiAlbert:
+		return BarabasiAlbert(n, model.BarabasiAlbert.GetM(), r)
+	case *proto.RandomGraph_WattsStrogatz:
+		return WattsStrogatz(n, model.WattsStrogatz.GetK(), model.WattsStrogatz.GetBeta(), r)
+	case *proto.RandomGraph_Rmat:
+		m := model.Rmat
+		return RMat(n, m.GetEdges(), m.GetA(), m.GetB(), m.GetC(), r)
+	}
+	if config.GetMaxNumberOfEdges() <= 0 {
+		return nil, fmt.Errorf("Invalid max number of edges %d", config.GetMaxNumberOfEdges())
+	}
+	return Generate(n, config.GetMaxNumberOfEdges(), r), nil
+}
+
+// Every edge u -> v (u != v) exists with probability p
+func ErdosRenyi(n int32, p float64, r *rand.Rand) (map[int32]*proto.GraphNode, error) {
+	if p < 0 || p > 1 || math.IsNaN(p) {
+		return nil, fmt.Errorf("Invalid edge probability %f", p)
+	}
+	graph := emptyGraph(n)
+	if p == 0 {
+		initialize(graph)
+		return graph, nil
+	}
+	// Skip directly to the next edge (geometric distribution), instead of
+	// testing all the n^2 pairs (Batagelj and Brandes)
+	total := int64(n) * int64(n-1)
+	for e := int64(-1); ; {
+		skip := 0.0
+		if p < 1 {
+			skip = math.Log(1-r.Float64()) / math.Log(1-p)
+		}
+		if skip >= float64(total-e-1) {
+			break
+		}
+		e += int64(skip) + 1
+		from := int32(e / int64(n-1))
+		to := int32(e % int64(n-1))
+		// Self-loops are not counted in the pairs
+		if to >= from {
+			to += 1
+		}
+		addEdge(graph, from, to)
+	}
+	initialize(graph)
+	return graph, nil
+}
+
+// Every new node links to m distinct existing nodes,
+// chosen with probability proportional to their degree
+func BarabasiAlbert(n, m int32, r *rand.Rand) (map[int32]*proto.GraphNode, error) {
+	if m <= 0 || m >= n {
+		return nil, fmt.Errorf("Invalid number of edges per node %d (nodes: %d)", m, n)
+	}
+	graph := emptyGraph(n)
+	// Every node appears once for every edge it has (plus once to be chosen
+	// by the first nodes): a uniform choice is preferential attachment
+	var repeated []int32
+	for i := int32(0); i < m; i++ {
+		repeated = append(repeated, i)
+	}
+	for from := m; from < n; from++ {
+		chosen := make(map[int32]bool, m)
+		targets := make([]int32, 0, m)
+		for int32(len(targets)) < m {
+			to := repeated[r.Intn(len(repeated))]
+			if !chosen[to] {
+				chosen[to] = true
+				targets = append(targets, to)
+			}
+		}
+		for _, to := range targets {
+			addEdge(graph, from, to)
+			repeated = append(repeated, to, from)
+		}
+	}
+	initialize(graph)
+	return graph, nil
+}
+
+// Ring lattice where every node links to its k nearest neighbours (k/2 on
+// each side, one more forward if k is odd); every edge is rewired to a
+// random node with probability beta
+func WattsStrogatz(n, k int32, beta float64, r *rand.Rand) (map[int32]*proto.GraphNode, error) {
+	if k <= 0 || k >= n {
+		return nil, fmt.Errorf("Invalid number of neighbours %d (nodes: %d)", k, n)
+	}
+	if beta < 0 || beta > 1 || math.IsNaN(beta) {
+		return nil, fmt.Errorf("Invalid rewiring probability %f", beta)
+	}
+	graph := emptyGraph(n)
+	for from := int32(0); from < n; from++ {
+		neighbours := make([]int32, 0, k)
+		for d := int32(1); d <= (k+1)/2; d++ {
+			neighbours = append(neighbours, (from+d)%n)
+		}
+		for d := int32(1); d <= k/2; d++ {
+			neighbours = append(neighbours, (from-d+n)%n)
+		}
+		linked := make(map[int32]bool, k)
+		for _, to := range neighbours {
+			linked[to] = true
+		}
+		for _, to := range neighbours {
+			if r.Float64() < beta {
+				// Rewire to a node that is not linked yet (no self-loops and
+				// no duplicate edges, so that there are always n*k edges)
+				delete(linked, to)
+				for {
+					w := r.Int31n(n)
+					if w != from && !linked[w] {
+						to = w
+						break
+					}
+				}
+				linked[to] = true
+			}
+			addEdge(graph, from, to)
+		}
+	}
+	initialize(graph)
+	return graph, nil
+}
+
+// Every edge is placed by recursively choosing a quadrant of the adjacency
+// matrix with probabilities a, b, c, d (self-loops and duplicates are discarded)
+func RMat(n, edges int32, a, b, c float64, r *rand.Rand) (map[int32]*proto.GraphNode, error) {
+	if edges <= 0 {
+		return nil, fmt.Errorf("Invalid number of edges %d", edges)
+	}
+	if a < 0 || b < 0 || c < 0 || a+b+c > 1 {
+		return nil, fmt.Errorf("Invalid quadrant probabilities (%f, %f, %f)", a, b, c)
+	}
+	graph := emptyGraph(n)
+	// Smallest power of 2 with at least n nodes
+	scale := 0
+	for int32(1)<<scale < n {
+		scale += 1
+	}
+	// Some edges are discarded: the number of tries is limited
+	tries := 16 * int64(edges)
+	for i := int64(0); i < tries && edges > 0; i++ {
+		from, to := int32(0), int32(0)
+		for bit := scale - 1; bit >= 0; bit-- {
+			p := r.Float64()
+			switch {
+			case p < a:
+			case p < a+b:
+				to |= 1 << bit
+			case p < a+b+c:
+				from |= 1 << bit
+			default:
+				from |= 1 << bit
+				to |= 1 << bit
+			}
+		}
+		if from >= n || to >= n || from == to || graph[to].InLinks[from] != nil {
+			continue
+		}
+		addEdge(graph, from, to)
+		edges -= 1
+	}
+	initialize(graph)
+	return graph, nil
+}
+
+// Graph with nodes from 0 to n - 1 and no edges
+func emptyGraph(n int32) map[int32]*proto.GraphNode {
+	graph := make(map[int32]*proto.GraphNode, n)
+	for i := int32(0); i < n; i++ {
+		graph[i] = &proto.GraphNode{
+			InLinks: make(map[int32]*proto.GraphNodeInfo),
+		}
+	}
+	return graph
+}
+
+// Edge: from -> to (weight 1)
+func addEdge(graph map[int32]*proto.GraphNode, from, to int32) {
+	graph[to].InLinks[from] = &proto.GraphNodeInfo{Probability: 1}
+}
+
+// Set number of outlinks, transition probabilities (from the edge weights,
+// stored in Probability), initial ranks and uniform E
+func initialize(graph map[int32]*proto.GraphNode) {
+	numberOfOutlinks := make(map[int32]int32)
+	outWeight := make(map[int32]float64)
+	for _, v := range graph {
+		for j, info := range v.InLinks {
+			numberOfOutlinks[j] += 1
+			outWeight[j] += info.Probability
+		}
+	}
+	initialRank := 1.0 / float64(len(graph))
+	for _, u := range graph {
+		u.Rank = initialRank
+		u.E = initialRank
+		for j, v := range u.InLinks {
+			v.Rank = initialRank
+			v.Outlinks = numberOfOutlinks[j]
+			v.Probability /= outWeight[j]
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name     string
+		generate func(r *rand.Rand) (map[int32]*proto.GraphNode, error)
+		nodes    int
+		edges    int // Expected number of edges (-1: not fixed)
+	}{
+		{"erdos-renyi empty", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return ErdosRenyi(20, 0, r) }, 20, 0},
+		{"erdos-renyi complete", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return ErdosRenyi(20, 1, r) }, 20, 20 * 19},
+		{"erdos-renyi", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return ErdosRenyi(200, 0.05, r) }, 200, -1},
+		{"barabasi-albert", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return BarabasiAlbert(100, 3, r) }, 100, (100 - 3) * 3},
+		{"watts-strogatz lattice", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return WattsStrogatz(50, 4, 0, r) }, 50, 50 * 4},
+		{"watts-strogatz odd k", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return WattsStrogatz(50, 3, 0.5, r) }, 50, 50 * 3},
+		{"watts-strogatz random", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return WattsStrogatz(50, 6, 1, r) }, 50, 50 * 6},
+		{"watts-strogatz dense", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return WattsStrogatz(8, 7, 1, r) }, 8, 8 * 7},
+		{"rmat", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return RMat(1000, 3000, 0.57, 0.19, 0.19, r) }, 1000, 3000},
+		{"rmat not a power of 2", func(r *rand.Rand) (map[int32]*proto.GraphNode, error) { return RMat(100, 200, 0.25, 0.25, 0.25, r) }, 100, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := test.generate(rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(g) != test.nodes {
+				t.Errorf("expected %d nodes, got %d", test.nodes, len(g))
+			}
+			edges := labelledEdges(g, nil)
+			if test.edges >= 0 && len(edges) != test.edges {
+				t.Errorf("expected %d edges, got %d", test.edges, len(edges))
+			}
+			outWeight := make(map[int32]float64)
+			for id, u := range g {
+				if id < 0 || int(id) >= test.nodes {
+					t.Fatalf("node %d out of range", id)
+				}
+				if _, ok := u.InLinks[id]; ok {
+					t.Errorf("self-loop on node %d", id)
+				}
+				for j, v := range u.InLinks {
+					if g[j] == nil {
+						t.Fatalf("edge from missing node %d", j)
+					}
+					outWeight[j] += v.Probability
+				}
+			}
+			// Transition probabilities of every node with outlinks sum to 1
+			for j, w := range outWeight {
+				if math.Abs(w-1) > 1e-9 {
+					t.Errorf("out-probabilities of node %d sum to %f", j, w)
+				}
+			}
+			// Same seed, same graph
+			again, _ := test.generate(rand.New(rand.NewSource(1)))
+			if strings.Join(labelledEdges(again, nil), " ") != strings.Join(edges, " ") {
+				t.Errorf("different graphs with the same seed")
+			}
+		})
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name   string
+		config *proto.RandomGraph
+		err    string
+	}{
+		{"no nodes", &proto.RandomGraph{NumberOfNodes: 0, MaxNumberOfEdges: 1}, "Invalid number of nodes"},
+		{"too many nodes", rmat(1<<30+1, 5, 0.25, 0.25, 0.25), "Invalid number of nodes"},
+		{"no edges", &proto.RandomGraph{NumberOfNodes: 10}, "Invalid max number of edges"},
+		{"probability over 1", erdosRenyi(10, 1.5), "Invalid edge probability"},
+		{"NaN probability", erdosRenyi(10, math.NaN()), "Invalid edge probability"},
+		{"m too large", barabasiAlbert(10, 10), "Invalid number of edges per node"},
+		{"m zero", barabasiAlbert(10, 0), "Invalid number of edges per node"},
+		{"k too large", wattsStrogatz(10, 10, 0.5), "Invalid number of neighbours"},
+		{"negative beta", wattsStrogatz(10, 2, -0.1), "Invalid rewiring probability"},
+		{"rmat no edges", rmat(10, 0, 0.25, 0.25, 0.25), "Invalid number of edges"},
+		{"rmat probabilities", rmat(10, 5, 0.5, 0.5, 0.5), "Invalid quadrant probabilities"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GenerateModel(test.config, r)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func erdosRenyi(n int32, p float64) *proto.RandomGraph {
+	return &proto.RandomGraph{
+		NumberOfNodes: n,
+		Model:         &proto.RandomGraph_ErdosRenyi{ErdosRenyi: &proto.ErdosRenyi{P: p}},
+	}
+}
+
+func barabasiAlbert(n, m int32) *proto.RandomGraph {
+	return &proto.RandomGraph{
+		NumberOfNodes: n,
+		Model:         &proto.RandomGraph_BarabasiAlbert{BarabasiAlbert: &proto.BarabasiAlbert{M: m}},
+	}
+}
+
+func wattsStrogatz(n, k int32, beta float64) *proto.RandomGraph {
+	return &proto.RandomGraph{
+		NumberOfNodes: n,
+		Model:         &proto.RandomGraph_WattsStrogatz{WattsStrogatz: &proto.WattsStrogatz{K: k, Beta: beta}},
+	}
+}
+
+func rmat(n, edges int32, a, b, c float64) *proto.RandomGraph {
+	return &proto.RandomGraph{
+		NumberOfNodes: n,
+		Model:         &proto.RandomGraph_Rmat{Rmat: &proto.RMat{Edges: edges, A: a, B: b, C: c}},
+	}
+}
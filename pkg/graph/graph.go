@@ -1,20 +1,31 @@
 package graph
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 
 	"github.com/lioia/distributed-pagerank/proto"
 )
 
-func LoadGraphResource(resource string) (g map[int32]*proto.GraphNode, err error) {
-	var bytes []byte
+// Options used to load a graph file
+type LoadOptions struct {
+	Resource        string // File name or URL (used to detect the format)
+	Format          string // Format name (default: detected)
+	MaxInvalidLines int    // Invalid lines skipped before failing (line based formats)
+	Weighted        bool   // Use the edge weights of the file
+}
+
+// Load the graph and the node labels (nil if the nodes are integers)
+func LoadGraphResource(resource string, options LoadOptions) (g map[int32]*proto.GraphNode, labels []string, err error) {
+	var reader io.Reader
+	contentType := ""
 	// Check if it's a network resource or a local one
 	if strings.HasPrefix(resource, "http") {
 		// Loading file from network
@@ -22,93 +33,77 @@ func LoadGraphResource(resource string) (g map[int32]*proto.GraphNode, err error
 		resp, err = http.Get(resource)
 		if err != nil {
 			log.Printf("Could not load network file at %s: %v", resource, err)
-			return nil, err
+			return nil, nil, err
 		}
 		defer resp.Body.Close()
-		// Read response body
-		bytes, err = io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Could not load body from request: %v", err)
-			return nil, err
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("Could not load network file at %s: %s", resource, resp.Status)
+			return nil, nil, fmt.Errorf("Request failed: %s", resp.Status)
 		}
+		reader = resp.Body
+		contentType = resp.Header.Get("Content-Type")
 	} else {
 		// Loading file from local filesystem
-		bytes, err = os.ReadFile(resource)
+		var file *os.File
+		file, err = os.Open(resource)
 		if err != nil {
 			log.Printf("Could not read graph at %s: %v", resource, err)
-			return nil, err
+			return nil, nil, err
 		}
+		defer file.Close()
+		reader = file
 	}
-	// Parse graph file into graph representation
-	g, err = LoadGraphFromBytes(bytes)
+	g, labels, err = LoadGraphStream(reader, resource, contentType, options)
 	if err != nil {
 		log.Printf("Could not load graph from %s: %v", resource, err)
-		return nil, err
+		return nil, nil, err
 	}
-	return g, nil
+	return g, labels, nil
 }
 
-func LoadGraphFromBytes(contents []byte) (map[int32]*proto.GraphNode, error) {
-	// Split file contents in lines (based on newline delimiter)
-	graph := make(map[int32]*proto.GraphNode)
-	numberOfOutlinks := make(map[int32]int32)
-	lines := strings.Split(strings.ReplaceAll(string(contents), "\r\n", "\n"), "\n")
-	for _, line := range lines {
-		from, to, skip, err := convertLine(line)
-		// There was an error loading the line
-		if err != nil {
-			return nil, err
-		}
-		// Comment line -> no new node to add
-		if skip {
-			continue
-		}
-		if graph[from] == nil {
-			graph[from] = &proto.GraphNode{
-				InLinks: make(map[int32]*proto.GraphNodeInfo),
-			}
-			numberOfOutlinks[from] = 0
-		}
-		if graph[to] == nil {
-			graph[to] = &proto.GraphNode{
-				InLinks: make(map[int32]*proto.GraphNodeInfo),
-			}
-		}
-		graph[to].InLinks[from] = &proto.GraphNodeInfo{}
-		numberOfOutlinks[from] += 1
+// Load a graph file while it is received (name and content type are used to
+// detect the compression and the format)
+func LoadGraphStream(r io.Reader, name, contentType string, options LoadOptions) (map[int32]*proto.GraphNode, []string, error) {
+	// Compressed files are decompressed while they are read
+	contents, err := decompress(r, name, contentType)
+	if err != nil {
+		return nil, nil, err
 	}
-	initialRank := 1.0 / float64(len(graph))
-	total := 0.0
-	for _, u := range graph {
-		probability := rand.Float64()
-		u.Rank = initialRank
-		u.E = probability
-		total += probability
-		for j, v := range u.InLinks {
-			v.Rank = initialRank
-			v.Outlinks = numberOfOutlinks[j]
-		}
+	defer contents.Close()
+	// Parse graph file into graph representation
+	if options.Resource == "" {
+		options.Resource = name
 	}
+	return LoadGraph(contents, options)
+}
 
-	// Normalize E values (sum i equal to 1)
-	for _, v := range graph {
-		v.E /= total
+// Build the graph while reading the file, in the format of the options
+// Labels are returned if the nodes are not integers (label of ID i is labels[i])
+func LoadGraph(r io.Reader, options LoadOptions) (map[int32]*proto.GraphNode, []string, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	name, format, err := detectFormat(reader, options)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return graph, nil
+	b := NewGraphBuilder(options.Weighted)
+	if err := format.Read(reader, options, b); err != nil {
+		return nil, nil, fmt.Errorf("Invalid %s file: %v", name, err)
+	}
+	g, labels := b.Build()
+	return g, labels, nil
 }
 
-func Generate(numberOfNodes, maxNumberOfEdges int32) map[int32]*proto.GraphNode {
+// Random values are generated by r (the same seed gives the same graph)
+func Generate(numberOfNodes, maxNumberOfEdges int32, r *rand.Rand) map[int32]*proto.GraphNode {
 	graph := make(map[int32]*proto.GraphNode)
-	numberOfOutlinks := make(map[int32]int32)
 	for from := 0; from < int(numberOfNodes); from++ {
 		// Generate number of edges for node from
-		outlinks := rand.Int31n(maxNumberOfEdges) + 1
+		outlinks := r.Int31n(maxNumberOfEdges) + 1
 		for j := 0; j < int(outlinks); j++ {
 			// Generate node to
-			to := rand.Int31n(numberOfNodes)
+			to := r.Int31n(numberOfNodes)
 			for to == int32(from) {
-				to = rand.Int31n(numberOfNodes)
+				to = r.Int31n(numberOfNodes)
 			}
 			// Initialize from and to if they don't exist
 			if graph[int32(from)] == nil {
@@ -122,7 +117,7 @@ func Generate(numberOfNodes, maxNumberOfEdges int32) map[int32]*proto.GraphNode
 				}
 			}
 			// Edge: from -> to
-			graph[to].InLinks[int32(from)] = &proto.GraphNodeInfo{}
+			addEdge(graph, int32(from), to)
 		}
 	}
 
@@ -133,64 +128,42 @@ func Generate(numberOfNodes, maxNumberOfEdges int32) map[int32]*proto.GraphNode
 			continue
 		}
 		if graph[i].InLinks[i-1] == nil {
-			graph[i].InLinks[i-1] = &proto.GraphNodeInfo{}
-		}
-	}
-
-	// Find out number of outlinks
-	for _, v := range graph {
-		for j := range v.InLinks {
-			numberOfOutlinks[j] += 1
-		}
-	}
-
-	// Set default values
-	initialRank := 1.0 / float64(numberOfNodes)
-	total := 0.0
-	for _, u := range graph {
-		probability := rand.Float64()
-		u.Rank = initialRank
-		u.E = probability
-		total += probability
-		for j, v := range u.InLinks {
-			v.Rank = initialRank
-			v.Outlinks = numberOfOutlinks[j]
+			addEdge(graph, i-1, i)
 		}
 	}
 
-	// Normalize E values (sum i equal to 1)
-	for _, v := range graph {
-		v.E /= total
-	}
+	// Set default values (uniform E, it can be replaced with SetE)
+	initialize(graph)
 	return graph
 }
 
-func ConvertToDot(g map[int32]*proto.GraphNode) string {
-	dot := "digraph {"
-	for i, v := range g {
-		for j := range v.InLinks {
-			dot = fmt.Sprintf("%s %d -> %d;", dot, j, i)
+// Edges of the graph as (from, to) pairs, sorted
+func Edges(g map[int32]*proto.GraphNode) []int32 {
+	var pairs [][2]int32
+	for to, node := range g {
+		for from := range node.InLinks {
+			pairs = append(pairs, [2]int32{from, to})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
 		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	edges := make([]int32, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		edges = append(edges, pair[0], pair[1])
 	}
-	return fmt.Sprintf("%s}", dot)
+	return edges
 }
 
-func convertLine(line string) (int32, int32, bool, error) {
-	// Skip comment lines
-	if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") || line == "" {
-		return 0, 0, true, nil
-	}
-	// Convert line to csv format
-	line = strings.Replace(line, " ", ",", 1)
-	// Split line in FromNode and ToNode
-	tokens := strings.Split(line, ",")
-	from, err := strconv.Atoi(tokens[0])
-	if err != nil {
-		return 0, 0, false, fmt.Errorf("Could not convert FromNode %s", tokens[0])
-	}
-	to, err := strconv.Atoi(tokens[1])
-	if err != nil {
-		return 0, 0, false, fmt.Errorf("Could not convert ToNode %s", tokens[1])
+// Outlinks of the node as (from, to) pairs (edges sorted as returned by Edges)
+func OutEdges(edges []int32, id int32) []int32 {
+	start := sort.Search(len(edges)/2, func(i int) bool { return edges[2*i] >= id })
+	end := start
+	for end < len(edges)/2 && edges[2*end] == id {
+		end += 1
 	}
-	return int32(from), int32(to), false, nil
+	return edges[2*start : 2*end]
 }
@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GraphML format (e.g. Gephi exports): node IDs are the labels; the edge
+// weight is the data whose key is named weight
+type GraphMLFormat struct{}
+
+type graphMLEdge struct {
+	Source   string `xml:"source,attr"`
+	Target   string `xml:"target,attr"`
+	Directed string `xml:"directed,attr"`
+	Data     []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"data"`
+}
+
+func (GraphMLFormat) Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error {
+	decoder := xml.NewDecoder(r)
+	weightKeys := make(map[string]bool)
+	directed := true
+	found := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "graphml":
+			found = true
+		case "key":
+			// Keys used for the edge weights
+			name, domain, id := attr(start, "attr.name"), attr(start, "for"), attr(start, "id")
+			if strings.EqualFold(name, "weight") && (domain == "edge" || domain == "all") {
+				weightKeys[id] = true
+			}
+		case "graph":
+			directed = attr(start, "edgedefault") != "undirected"
+		case "node":
+			if err := b.AddNode(attr(start, "id")); err != nil {
+				return err
+			}
+		case "edge":
+			var e graphMLEdge
+			if err := decoder.DecodeElement(&e, &start); err != nil {
+				return err
+			}
+			line, _ := decoder.InputPos()
+			if e.Source == "" || e.Target == "" {
+				return fmt.Errorf("Line %d: Edge without source or target", line)
+			}
+			weight := 1.0
+			for _, data := range e.Data {
+				if !options.Weighted || !weightKeys[data.Key] {
+					continue
+				}
+				weight, err = strconv.ParseFloat(strings.TrimSpace(data.Value), 64)
+				if err != nil || !(weight > 0) || math.IsInf(weight, 0) {
+					return fmt.Errorf("Line %d: Invalid Weight %q (expecting a positive number)", line, data.Value)
+				}
+			}
+			edge := Edge{From: e.Source, To: e.Target, Weight: weight}
+			if err := b.AddEdge(edge); err != nil {
+				return fmt.Errorf("Line %d: %v", line, err)
+			}
+			undirected := e.Directed == "false" || (e.Directed == "" && !directed)
+			if undirected && e.Source != e.Target {
+				edge.From, edge.To = edge.To, edge.From
+				if err := b.AddEdge(edge); err != nil {
+					return fmt.Errorf("Line %d: %v", line, err)
+				}
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("Missing graphml element")
+	}
+	return nil
+}
+
+func attr(element xml.StartElement, name string) string {
+	for _, a := range element.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// JSON adjacency format: object with the outlinks of every node, either as a
+// list of neighbours ({"a": ["b", "c"]}) or as neighbour -> edge weight
+// ({"a": {"b": 2, "c": 1}}); nodes can be strings or numbers
+type JSONFormat struct{}
+
+func (JSONFormat) Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		from, err := jsonLabel(decoder)
+		if err != nil {
+			return err
+		}
+		if err := b.AddNode(from); err != nil {
+			return err
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('['):
+			for decoder.More() {
+				to, err := jsonLabel(decoder)
+				if err != nil {
+					return err
+				}
+				if err := b.AddEdge(Edge{From: from, To: to, Weight: 1}); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(decoder, ']'); err != nil {
+				return err
+			}
+		case json.Delim('{'):
+			for decoder.More() {
+				to, err := jsonLabel(decoder)
+				if err != nil {
+					return err
+				}
+				var value json.Number
+				if err := decoder.Decode(&value); err != nil {
+					return fmt.Errorf("Offset %d: Invalid Weight of edge %s -> %s: %v", decoder.InputOffset(), from, to, err)
+				}
+				weight := 1.0
+				if options.Weighted {
+					weight, err = value.Float64()
+					if err != nil || !(weight > 0) || math.IsInf(weight, 0) {
+						return fmt.Errorf("Offset %d: Invalid Weight %s (expecting a positive number)", decoder.InputOffset(), value)
+					}
+				}
+				if err := b.AddEdge(Edge{From: from, To: to, Weight: weight}); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(decoder, '}'); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Offset %d: Expecting the neighbours of %s (list or object)", decoder.InputOffset(), from)
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// Node label: object key, string or number
+func jsonLabel(decoder *json.Decoder) (string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	switch v := token.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("Offset %d: Expecting a node, found %v", decoder.InputOffset(), token)
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Offset %d: Expecting %s, found %v", decoder.InputOffset(), delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Node labels -> dense IDs (in order of appearance)
+type dictionary struct {
+	ids    map[string]int32
+	labels []string // Dense ID -> label
+}
+
+func newDictionary() *dictionary {
+	return &dictionary{ids: make(map[string]int32)}
+}
+
+// Dense ID of the label (added if it is new)
+func (d *dictionary) dense(label string) (int32, error) {
+	if id, ok := d.ids[label]; ok {
+		return id, nil
+	}
+	if len(d.labels) == 1<<31-1 {
+		return 0, fmt.Errorf("Too many nodes")
+	}
+	id := int32(len(d.labels))
+	d.ids[label] = id
+	d.labels = append(d.labels, label)
+	return id, nil
+}
+
+// ID of the node: while every label is an integer, the label is the ID and
+// no dictionary is kept (integer labels with a different format, e.g. 01
+// and 1, are the same node); otherwise IDs are dense
+func (b *GraphBuilder) id(label string) (int32, error) {
+	if b.dictionary == nil {
+		if value, err := strconv.ParseInt(label, 10, 32); err == nil {
+			return int32(value), nil
+		}
+		if err := b.relabel(); err != nil {
+			return 0, err
+		}
+	}
+	return b.dictionary.dense(label)
+}
+
+// The labels are no longer integers: the nodes read so far get dense IDs
+// (sorted by their integer label, which becomes their label)
+func (b *GraphBuilder) relabel() error {
+	b.dictionary = newDictionary()
+	ids := make(map[int32]int32, len(b.graph))
+	for _, id := range sortedIds(b.graph) {
+		dense, err := b.dictionary.dense(strconv.FormatInt(int64(id), 10))
+		if err != nil {
+			return err
+		}
+		ids[id] = dense
+	}
+	graph := make(map[int32]*proto.GraphNode, len(b.graph))
+	for id, u := range b.graph {
+		inLinks := make(map[int32]*proto.GraphNodeInfo, len(u.InLinks))
+		for from, info := range u.InLinks {
+			inLinks[ids[from]] = info
+		}
+		u.InLinks = inLinks
+		graph[ids[id]] = u
+	}
+	b.graph = graph
+	return nil
+}
@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// METIS graph format: header (nodes, edges, [fmt, [ncon]]) followed by one
+// line for every node (1-based) with its neighbours; the edges of a line go
+// from the node to its neighbours (every undirected edge is listed by both
+// its nodes, so both directions are read)
+type MetisFormat struct{}
+
+func (MetisFormat) Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error {
+	scanner := newLineScanner(r)
+	line := 0
+	nodes := int64(-1)
+	node := int64(0)
+	// fmt: node sizes, node weights, edge weights
+	var sizes, edgeWeights bool
+	var nodeWeights int
+	for scanner.Scan() {
+		line += 1
+		text := strings.TrimSpace(scanner.Text())
+		// Skip comment lines (empty lines are nodes without neighbours)
+		if strings.HasPrefix(text, "%") || (nodes < 0 && text == "") {
+			continue
+		}
+		tokens := strings.Fields(text)
+		if nodes < 0 {
+			if len(tokens) < 2 || len(tokens) > 4 {
+				return fmt.Errorf("Line %d: Expecting header (nodes edges [fmt [ncon]]), found %q", line, text)
+			}
+			value, err := strconv.ParseInt(tokens[0], 10, 32)
+			if err != nil || value < 0 {
+				return fmt.Errorf("Line %d: Invalid number of nodes %q", line, tokens[0])
+			}
+			nodes = value
+			if len(tokens) > 2 {
+				format := tokens[2]
+				if len(format) > 3 || strings.Trim(format, "01") != "" {
+					return fmt.Errorf("Line %d: Invalid fmt %q", line, format)
+				}
+				format = strings.Repeat("0", 3-len(format)) + format
+				sizes, edgeWeights = format[0] == '1', format[2] == '1'
+				if format[1] == '1' {
+					nodeWeights = 1
+				}
+			}
+			if len(tokens) > 3 {
+				ncon, err := strconv.Atoi(tokens[3])
+				if err != nil || ncon < 0 {
+					return fmt.Errorf("Line %d: Invalid ncon %q", line, tokens[3])
+				}
+				if nodeWeights > 0 {
+					nodeWeights = ncon
+				}
+			}
+			// Every node is added (even without neighbours)
+			for i := int64(1); i <= nodes; i++ {
+				if err := b.AddNode(strconv.FormatInt(i, 10)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if node == nodes {
+			if text == "" {
+				continue
+			}
+			return fmt.Errorf("Line %d: More than %d nodes", line, nodes)
+		}
+		node += 1
+		// Node size and weights are not used
+		skip := nodeWeights
+		if sizes {
+			skip += 1
+		}
+		if len(tokens) < skip {
+			return fmt.Errorf("Line %d: Expecting %d node values, found %q", line, skip, text)
+		}
+		tokens = tokens[skip:]
+		step := 1
+		if edgeWeights {
+			step = 2
+			if len(tokens)%2 != 0 {
+				return fmt.Errorf("Line %d: Expecting neighbour and weight pairs, found %q", line, text)
+			}
+		}
+		from := strconv.FormatInt(node, 10)
+		for i := 0; i < len(tokens); i += step {
+			neighbour, err := strconv.ParseInt(tokens[i], 10, 32)
+			if err != nil || neighbour < 1 || neighbour > nodes {
+				return fmt.Errorf("Line %d: Invalid neighbour %q", line, tokens[i])
+			}
+			weight := 1.0
+			if edgeWeights && options.Weighted {
+				weight, err = strconv.ParseFloat(tokens[i+1], 64)
+				if err != nil || !(weight > 0) || math.IsInf(weight, 0) {
+					return fmt.Errorf("Line %d: Invalid Weight %q (expecting a positive number)", line, tokens[i+1])
+				}
+			}
+			edge := Edge{From: from, To: strconv.FormatInt(neighbour, 10), Weight: weight}
+			if err := b.AddEdge(edge); err != nil {
+				return fmt.Errorf("Line %d: %v", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Line %d: %v", line+1, err)
+	}
+	if nodes < 0 {
+		return fmt.Errorf("Missing header")
+	}
+	if node < nodes {
+		return fmt.Errorf("Expecting %d nodes, found %d", nodes, node)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Matrix Market coordinate format: entry (i, j) is the edge i -> j
+// (1-based indexes); symmetric matrices have the edges in both directions
+type MatrixMarketFormat struct{}
+
+func (MatrixMarketFormat) Read(r *bufio.Reader, options LoadOptions, b *GraphBuilder) error {
+	scanner := newLineScanner(r)
+	line := 0
+	// Header: %%MatrixMarket matrix coordinate <field> <symmetry>
+	if !scanner.Scan() {
+		return fmt.Errorf("Missing header")
+	}
+	line += 1
+	header := strings.Fields(strings.ToLower(scanner.Text()))
+	if len(header) != 5 || header[0] != "%%matrixmarket" || header[1] != "matrix" || header[2] != "coordinate" {
+		return fmt.Errorf("Line 1: Unsupported header %q (expecting a coordinate matrix)", scanner.Text())
+	}
+	field, symmetry := header[3], header[4]
+	if field != "real" && field != "integer" && field != "pattern" {
+		return fmt.Errorf("Line 1: Unsupported field %s", field)
+	}
+	if symmetry != "general" && symmetry != "symmetric" && symmetry != "skew-symmetric" {
+		return fmt.Errorf("Line 1: Unsupported symmetry %s", symmetry)
+	}
+	var rows, columns, entries int64 = -1, 0, 0
+	read := int64(0)
+	for scanner.Scan() {
+		line += 1
+		text := strings.TrimSpace(scanner.Text())
+		// Skip comment and empty lines
+		if text == "" || strings.HasPrefix(text, "%") {
+			continue
+		}
+		tokens := strings.Fields(text)
+		if rows < 0 {
+			// Size line: rows columns entries
+			if len(tokens) != 3 {
+				return fmt.Errorf("Line %d: Expecting rows, columns and entries, found %q", line, text)
+			}
+			sizes := make([]int64, 3)
+			for i, token := range tokens {
+				value, err := strconv.ParseInt(token, 10, 32)
+				if err != nil || value < 0 {
+					return fmt.Errorf("Line %d: Invalid size %q", line, token)
+				}
+				sizes[i] = value
+			}
+			rows, columns, entries = sizes[0], sizes[1], sizes[2]
+			// Every row and column is a node (even without edges)
+			for i := int64(1); i <= rows || i <= columns; i++ {
+				if err := b.AddNode(strconv.FormatInt(i, 10)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if read == entries {
+			return fmt.Errorf("Line %d: More than %d entries", line, entries)
+		}
+		if len(tokens) < 2 || (field != "pattern" && len(tokens) < 3) {
+			return fmt.Errorf("Line %d: Expecting an entry, found %q", line, text)
+		}
+		i, err := strconv.ParseInt(tokens[0], 10, 32)
+		if err != nil || i < 1 || i > rows {
+			return fmt.Errorf("Line %d: Invalid row %q", line, tokens[0])
+		}
+		j, err := strconv.ParseInt(tokens[1], 10, 32)
+		if err != nil || j < 1 || j > columns {
+			return fmt.Errorf("Line %d: Invalid column %q", line, tokens[1])
+		}
+		weight := 1.0
+		if field != "pattern" {
+			value, err := strconv.ParseFloat(tokens[2], 64)
+			if symmetry == "skew-symmetric" {
+				value = math.Abs(value)
+			}
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				return fmt.Errorf("Line %d: Invalid Weight %q (expecting a number)", line, tokens[2])
+			}
+			// Explicit zeros (and negative values) are not edges
+			if value <= 0 {
+				log.Printf("Skipping entry with non-positive weight at line %d: %q", line, text)
+				read += 1
+				continue
+			}
+			if options.Weighted {
+				weight = value
+			}
+		}
+		from, to := strconv.FormatInt(i, 10), strconv.FormatInt(j, 10)
+		if err := b.AddEdge(Edge{From: from, To: to, Weight: weight}); err != nil {
+			return fmt.Errorf("Line %d: %v", line, err)
+		}
+		if symmetry != "general" && i != j {
+			if err := b.AddEdge(Edge{From: to, To: from, Weight: weight}); err != nil {
+				return fmt.Errorf("Line %d: %v", line, err)
+			}
+		}
+		read += 1
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Line %d: %v", line+1, err)
+	}
+	if rows < 0 {
+		return fmt.Errorf("Missing size line")
+	}
+	if read < entries {
+		return fmt.Errorf("Expecting %d entries, found %d", entries, read)
+	}
+	return nil
+}
@@ -7,46 +7,147 @@ import (
 	"github.com/lioia/distributed-pagerank/proto"
 )
 
-// R_(i + 1) (u) = c sum_(v in B_u) (R_i(v) / N_v) + (1 - c)E(u)
-func SingleNodePageRank(graph map[int32]*proto.GraphNode, c, threshold float64) int32 {
-	for i := 0; i < 100; i++ {
-		sum := make(map[int32]float64, len(graph))
-		for id, u := range graph {
-			// Map Phase: sum_(v in B_u) (R_i(v) / N_v)
-			for _, v := range u.InLinks {
-				sum[id] += v.Rank / float64(v.Outlinks)
-			}
-		}
+// R_(i + 1) (u) = c sum_(v in B_u) (R_i(v) * P(v -> u)) + (1 - c)E(u)
+// The computation starts from iteration (with the ranks of the graph); next is
+// called with the convergence delta of every iteration that did not converge,
+// before the next one starts (the computation stops if it returns false), and
+// can store the current ranks in the graph; the delta of the last one is returned
+func SingleNodePageRank(graph map[int32]*proto.GraphNode, c, threshold float64, dangling proto.Dangling, iteration int32, next func(delta float64, storeRanks func()) bool) float64 {
+	g := NewCSR(graph)
+	storeRanks := func() { g.StoreRanks(graph) }
+	delta := g.PageRank(c, threshold, dangling, iteration, func(delta float64) bool {
+		return next(delta, storeRanks)
+	})
+	g.StoreRanks(graph)
+	return delta
+}
+
+func (g *CSR) PageRank(c, threshold float64, dangling proto.Dangling, iteration int32, next func(delta float64) bool) float64 {
+	convergenceDiff := 0.0
+	for i := iteration; i < 100; i++ {
+		// Map Phase: sum_(v in B_u) (R_i(v) * P(v -> u))
+		sum := g.Contributions(g.Rank)
+		// Collect phase: rank of the nodes without outlinks
+		g.AddDangling(dangling, g.Rank, sum)
 
 		// Reduce phase (convergence check): R_(i + 1) (u) = c * sum + (1-c)*E(u)
-		convergenceDiff := 0.0
-		for id, node := range graph {
-			oldRank := node.Rank
-			newRank := c*sum[id] + (1-c)*node.E
-			convergenceDiff += math.Abs(newRank - oldRank)
-			graph[id].Rank = newRank
-		}
-		// Update InLinks rank
-		for _, node := range graph {
-			for j, in := range node.InLinks {
-				in.Rank = graph[j].Rank
-			}
-		}
+		convergenceDiff = Reduce(c, sum, g.E, g.Rank)
 
 		if convergenceDiff < threshold {
 			utils.NodeLog("master", "Convergence check success (%d iterations)", i+1)
 			// Normalize values
-			rankSum := 0.0
-			for _, node := range graph {
-				rankSum += node.Rank
-			}
-			for i := range graph {
-				graph[i].Rank /= rankSum
-			}
-			return int32(i)
+			rankSum := parallelSum(len(g.Rank), func(start, end int) float64 {
+				partial := 0.0
+				for _, rank := range g.Rank[start:end] {
+					partial += rank
+				}
+				return partial
+			})
+			parallelFor(len(g.Rank), func(start, end int) {
+				for u := start; u < end; u++ {
+					g.Rank[u] /= rankSum
+				}
+			})
+			return convergenceDiff
 		} else {
 			utils.NodeLog("master", "Convergence check failed (%f)", convergenceDiff)
+			if !next(convergenceDiff) {
+				return convergenceDiff
+			}
 		}
 	}
-	return 100
+	return convergenceDiff
+}
+
+// Map phase: sum_(v in B_u) (R(v) * P(v -> u)) for the nodes with stored in-links
+// (P(v -> u) = 1 / N_v if the edges are not weighted)
+func (g *CSR) Contributions(rank []float64) []float64 {
+	sum := make([]float64, g.Nodes)
+	parallelFor(g.Nodes, func(start, end int) {
+		for u := start; u < end; u++ {
+			for k := g.InStart[u]; k < g.InStart[u+1]; k++ {
+				sum[u] += rank[g.InLinks[k]] * g.Weight[k]
+			}
+		}
+	})
+	return sum
+}
+
+// Reduce phase: R(u) = c * sum(u) + (1-c)*E(u), updating rank in place
+// Returns the convergence value (sum of the rank differences)
+func Reduce(c float64, sum, e, rank []float64) float64 {
+	return parallelSum(len(rank), func(start, end int) float64 {
+		diff := 0.0
+		for u := start; u < end; u++ {
+			newRank := ReduceRank(c, sum[u], e[u])
+			diff += math.Abs(newRank - rank[u])
+			rank[u] = newRank
+		}
+		return diff
+	})
+}
+
+// Reduce phase of a partition: new rank of every node from its sum and E value
+func ReduceRanks(c float64, sum, e []float64) []float64 {
+	rank := make([]float64, len(sum))
+	parallelFor(len(sum), func(start, end int) {
+		for u := start; u < end; u++ {
+			rank[u] = ReduceRank(c, sum[u], e[u])
+		}
+	})
+	return rank
+}
+
+// Rank of a node from the sum of its contributions and its E value
+func ReduceRank(c, sum, e float64) float64 {
+	return c*sum + (1-c)*e
+}
+
+// Collect phase: the rank of the nodes without outlinks (dangling) is
+// redistributed as defined by the strategy, instead of being lost
+// (the graph has to be complete, i.e. not a partition)
+func (g *CSR) AddDangling(strategy proto.Dangling, rank, sum []float64) {
+	if strategy == proto.Dangling_DANGLING_SELF_LOOP {
+		// The only outlink is to the node itself: R(u) / 1
+		parallelFor(g.Nodes, func(start, end int) {
+			for u := start; u < end; u++ {
+				if g.Degree[u] == 0 {
+					sum[u] += rank[u]
+				}
+			}
+		})
+		return
+	}
+	mass := parallelSum(g.Nodes, func(start, end int) float64 {
+		partial := 0.0
+		for u := start; u < end; u++ {
+			if g.Degree[u] == 0 {
+				partial += rank[u]
+			}
+		}
+		return partial
+	})
+	if mass == 0 {
+		return
+	}
+	eSum := 0.0
+	if strategy == proto.Dangling_DANGLING_E {
+		eSum = parallelSum(g.Nodes, func(start, end int) float64 {
+			partial := 0.0
+			for _, e := range g.E[start:end] {
+				partial += e
+			}
+			return partial
+		})
+	}
+	parallelFor(g.Nodes, func(start, end int) {
+		for u := start; u < end; u++ {
+			if eSum > 0 {
+				sum[u] += mass * g.E[u] / eSum
+			} else {
+				// Uniform (also used if E is zero everywhere)
+				sum[u] += mass / float64(g.Nodes)
+			}
+		}
+	})
 }
@@ -0,0 +1,53 @@
+package graph
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Number of nodes computed by a goroutine at a time: it does not depend on
+// the number of cores, so that the results are the same on every machine
+const chunkSize = 2048
+
+// Split [0, n) in chunks computed by GOMAXPROCS goroutines
+func parallelFor(n int, fn func(start, end int)) {
+	chunks := (n + chunkSize - 1) / chunkSize
+	workers := runtime.GOMAXPROCS(0)
+	if workers > chunks {
+		workers = chunks
+	}
+	next := make(chan int, chunks)
+	for i := 0; i < chunks; i++ {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				end := (i + 1) * chunkSize
+				if end > n {
+					end = n
+				}
+				fn(i*chunkSize, end)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Like parallelFor, but fn returns a partial value for its chunk, and the
+// partial values are added in chunk order (deterministic reduction)
+func parallelSum(n int, fn func(start, end int) float64) float64 {
+	partials := make([]float64, (n+chunkSize-1)/chunkSize)
+	parallelFor(n, func(start, end int) {
+		partials[start/chunkSize] = fn(start, end)
+	})
+	total := 0.0
+	for _, v := range partials {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,217 @@
+package graph
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Strategy used to divide the graph in k sub-graphs (one for each sub-job)
+type Partitioner interface {
+	// Node IDs of every partition (sorted); partitions can be empty
+	Partition(graph map[int32]*proto.GraphNode, k int) [][]int32
+}
+
+// Node to partition by hashing its ID
+type HashPartitioner struct{}
+
+// Contiguous ranges of IDs with the same number of nodes
+type RangePartitioner struct{}
+
+// Partitions with (about) the same number of in-links,
+// i.e. the same amount of work in the Map phase
+type InDegreePartitioner struct{}
+
+// Locality-aware: starting from contiguous ranges, nodes are moved to the
+// partition of most of their neighbours (label propagation), as long as
+// the partition does not grow over its capacity
+type LabelPropagationPartitioner struct {
+	Iterations int // Number of propagation rounds
+}
+
+// Partition quality
+type PartitionStats struct {
+	Partitions int     // Number of non-empty partitions
+	Edges      int     // Number of edges in the graph
+	EdgeCut    int     // Edges between nodes of different partitions
+	Balance    float64 // Largest partition (in-links) over the average one (1: perfect balance)
+}
+
+func NewPartitioner(strategy proto.Partitioning) Partitioner {
+	switch strategy {
+	case proto.Partitioning_PARTITIONING_RANGE:
+		return RangePartitioner{}
+	case proto.Partitioning_PARTITIONING_IN_DEGREE:
+		return InDegreePartitioner{}
+	case proto.Partitioning_PARTITIONING_LABEL_PROPAGATION:
+		return LabelPropagationPartitioner{Iterations: 10}
+	}
+	return HashPartitioner{}
+}
+
+func (HashPartitioner) Partition(graph map[int32]*proto.GraphNode, k int) [][]int32 {
+	partitions := make([][]int32, k)
+	for _, id := range sortedIds(graph) {
+		// Multiplicative hashing (Knuth): consecutive IDs are spread between partitions
+		p := int((uint32(id) * 2654435761) % uint32(k))
+		partitions[p] = append(partitions[p], id)
+	}
+	return partitions
+}
+
+func (RangePartitioner) Partition(graph map[int32]*proto.GraphNode, k int) [][]int32 {
+	return rangePartition(sortedIds(graph), k)
+}
+
+func (InDegreePartitioner) Partition(graph map[int32]*proto.GraphNode, k int) [][]int32 {
+	ids := sortedIds(graph)
+	// Greedy: heaviest nodes first, each one to the lightest partition
+	sort.SliceStable(ids, func(i, j int) bool {
+		return len(graph[ids[i]].InLinks) > len(graph[ids[j]].InLinks)
+	})
+	loads := make(partitionLoads, k)
+	for i := range loads {
+		loads[i] = &partitionLoad{index: i}
+	}
+	heap.Init(&loads)
+	partitions := make([][]int32, k)
+	for _, id := range ids {
+		lightest := loads[0]
+		partitions[lightest.index] = append(partitions[lightest.index], id)
+		// Every node has a cost, even without in-links (Reduce phase)
+		lightest.load += len(graph[id].InLinks) + 1
+		heap.Fix(&loads, 0)
+	}
+	for _, p := range partitions {
+		sort.Slice(p, func(i, j int) bool { return p[i] < p[j] })
+	}
+	return partitions
+}
+
+func (l LabelPropagationPartitioner) Partition(graph map[int32]*proto.GraphNode, k int) [][]int32 {
+	ids := sortedIds(graph)
+	label := make(map[int32]int, len(ids))
+	sizes := make([]int, k)
+	for p, nodes := range rangePartition(ids, k) {
+		for _, id := range nodes {
+			label[id] = p
+		}
+		sizes[p] = len(nodes)
+	}
+	// Neighbours in both directions (undirected view of the graph)
+	neighbours := make(map[int32][]int32, len(ids))
+	for _, id := range ids {
+		for j := range graph[id].InLinks {
+			neighbours[id] = append(neighbours[id], j)
+			neighbours[j] = append(neighbours[j], id)
+		}
+	}
+	capacity := (len(ids)+k-1)/k + len(ids)/(20*k) // 5% imbalance allowed
+	for i := 0; i < l.Iterations; i++ {
+		moved := 0
+		for _, id := range ids {
+			counts := make([]int, k)
+			for _, j := range neighbours[id] {
+				counts[label[j]] += 1
+			}
+			current := label[id]
+			best := current
+			for p := 0; p < k; p++ {
+				if counts[p] > counts[best] && sizes[p] < capacity {
+					best = p
+				}
+			}
+			if best != current {
+				label[id] = best
+				sizes[current] -= 1
+				sizes[best] += 1
+				moved += 1
+			}
+		}
+		if moved == 0 {
+			break
+		}
+	}
+	partitions := make([][]int32, k)
+	for _, id := range ids {
+		partitions[label[id]] = append(partitions[label[id]], id)
+	}
+	return partitions
+}
+
+func Stats(graph map[int32]*proto.GraphNode, partitions [][]int32) PartitionStats {
+	stats := PartitionStats{}
+	partitionOf := make(map[int32]int, len(graph))
+	for p, nodes := range partitions {
+		for _, id := range nodes {
+			partitionOf[id] = p
+		}
+	}
+	maxLoad := 0
+	for _, nodes := range partitions {
+		if len(nodes) == 0 {
+			continue
+		}
+		stats.Partitions += 1
+		load := 0
+		for _, id := range nodes {
+			load += len(graph[id].InLinks)
+			for j := range graph[id].InLinks {
+				if partitionOf[j] != partitionOf[id] {
+					stats.EdgeCut += 1
+				}
+			}
+		}
+		stats.Edges += load
+		if load > maxLoad {
+			maxLoad = load
+		}
+	}
+	stats.Balance = 1
+	if stats.Edges > 0 {
+		stats.Balance = float64(maxLoad) / (float64(stats.Edges) / float64(stats.Partitions))
+	}
+	return stats
+}
+
+func rangePartition(ids []int32, k int) [][]int32 {
+	partitions := make([][]int32, k)
+	for p := 0; p < k; p++ {
+		partitions[p] = ids[p*len(ids)/k : (p+1)*len(ids)/k]
+	}
+	return partitions
+}
+
+func sortedIds(graph map[int32]*proto.GraphNode) []int32 {
+	ids := make([]int32, 0, len(graph))
+	for id := range graph {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Min-heap of partitions by load (used by InDegreePartitioner)
+type partitionLoad struct {
+	index int
+	load  int
+}
+
+type partitionLoads []*partitionLoad
+
+func (h partitionLoads) Len() int { return len(h) }
+func (h partitionLoads) Less(i, j int) bool {
+	if h[i].load == h[j].load {
+		return h[i].index < h[j].index
+	}
+	return h[i].load < h[j].load
+}
+func (h partitionLoads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *partitionLoads) Push(x any)   { *h = append(*h, x.(*partitionLoad)) }
+func (h *partitionLoads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
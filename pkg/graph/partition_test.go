@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+func TestPartitioners(t *testing.T) {
+	graph, err := BarabasiAlbert(100, 3, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Sparse IDs (not from 0 to n - 1)
+	sparse := map[int32]*proto.GraphNode{}
+	for _, id := range []int32{-7, 3, 10, 11, 1000} {
+		sparse[id] = &proto.GraphNode{InLinks: map[int32]*proto.GraphNodeInfo{}}
+	}
+	sparse[3].InLinks[-7] = &proto.GraphNodeInfo{}
+	sparse[1000].InLinks[3] = &proto.GraphNodeInfo{}
+	strategies := []proto.Partitioning{
+		proto.Partitioning_PARTITIONING_HASH,
+		proto.Partitioning_PARTITIONING_RANGE,
+		proto.Partitioning_PARTITIONING_IN_DEGREE,
+		proto.Partitioning_PARTITIONING_LABEL_PROPAGATION,
+	}
+	tests := []struct {
+		name  string
+		graph map[int32]*proto.GraphNode
+		k     int
+	}{
+		{"single partition", graph, 1},
+		{"three partitions", graph, 3},
+		{"eight partitions", graph, 8},
+		{"sparse ids", sparse, 2},
+		{"more partitions than nodes", sparse, 8},
+	}
+	for _, strategy := range strategies {
+		for _, test := range tests {
+			t.Run(strategy.String()+"/"+test.name, func(t *testing.T) {
+				partitions := NewPartitioner(strategy).Partition(test.graph, test.k)
+				if len(partitions) != test.k {
+					t.Fatalf("expected %d partitions, got %d", test.k, len(partitions))
+				}
+				seen := make(map[int32]bool, len(test.graph))
+				nonEmpty := 0
+				for p, nodes := range partitions {
+					if !sort.SliceIsSorted(nodes, func(i, j int) bool { return nodes[i] < nodes[j] }) {
+						t.Errorf("partition %d is not sorted: %v", p, nodes)
+					}
+					if len(nodes) > 0 {
+						nonEmpty += 1
+					}
+					for _, id := range nodes {
+						if test.graph[id] == nil {
+							t.Errorf("node %d is not in the graph", id)
+						}
+						if seen[id] {
+							t.Errorf("node %d in more than one partition", id)
+						}
+						seen[id] = true
+					}
+				}
+				if len(seen) != len(test.graph) {
+					t.Errorf("expected %d nodes, got %d", len(test.graph), len(seen))
+				}
+				stats := Stats(test.graph, partitions)
+				if stats.Partitions != nonEmpty {
+					t.Errorf("expected %d non-empty partitions, got %d", nonEmpty, stats.Partitions)
+				}
+				edges := len(labelledEdges(test.graph, nil))
+				if stats.Edges != edges {
+					t.Errorf("expected %d edges, got %d", edges, stats.Edges)
+				}
+				if test.k == 1 && stats.EdgeCut != 0 {
+					t.Errorf("expected no edge cut with one partition, got %d", stats.EdgeCut)
+				}
+				if strategy == proto.Partitioning_PARTITIONING_RANGE {
+					// Same number of nodes (at most one of difference)
+					smallest, largest := len(test.graph), 0
+					for _, nodes := range partitions {
+						if len(nodes) < smallest {
+							smallest = len(nodes)
+						}
+						if len(nodes) > largest {
+							largest = len(nodes)
+						}
+					}
+					if largest-smallest > 1 {
+						t.Errorf("range partitions from %d to %d nodes", smallest, largest)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Edges of the graph as "from->to" labels (sorted)
+func labelledEdges(g map[int32]*proto.GraphNode, labels []string) []string {
+	label := func(id int32) string {
+		if labels == nil {
+			return fmt.Sprint(id)
+		}
+		return labels[id]
+	}
+	var edges []string
+	for to, u := range g {
+		for from := range u.InLinks {
+			edges = append(edges, label(from)+"->"+label(to))
+		}
+	}
+	sort.Strings(edges)
+	return edges
+}
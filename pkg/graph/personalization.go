@@ -0,0 +1,84 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Set the E vector of the graph as defined by the configuration;
+// values are normalized (sum is equal to 1)
+// Random values are generated by r, unless the configuration has a seed
+// Nodes of the configuration are resolved through the labels (if any)
+func SetE(graph map[int32]*proto.GraphNode, labels []string, e *proto.Personalization, r *rand.Rand) error {
+	if len(graph) == 0 {
+		return fmt.Errorf("Graph is empty")
+	}
+	var ids map[string]int32
+	if labels != nil {
+		ids = make(map[string]int32, len(labels))
+		for id, label := range labels {
+			ids[label] = int32(id)
+		}
+	}
+	// ID of the node with the label (false if it is not in the graph)
+	resolve := func(label string) (int32, bool) {
+		if ids != nil {
+			id, ok := ids[label]
+			return id, ok
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(label), 10, 32)
+		return int32(id), err == nil && graph[int32(id)] != nil
+	}
+	values := make(map[int32]float64, len(graph))
+	switch e.GetMode() {
+	case proto.EMode_E_UNIFORM:
+		for id := range graph {
+			values[id] = 1
+		}
+	case proto.EMode_E_RANDOM:
+		// Sorted IDs: the same seed gives the same vector
+		if e.Seed != nil {
+			r = rand.New(rand.NewSource(e.GetSeed()))
+		}
+		for _, id := range sortedIds(graph) {
+			values[id] = r.Float64()
+		}
+	case proto.EMode_E_EXPLICIT:
+		for label, w := range e.GetWeights() {
+			id, ok := resolve(label)
+			if !ok {
+				return fmt.Errorf("Node %s of the E vector is not in the graph", label)
+			}
+			if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+				return fmt.Errorf("Invalid E weight %f for node %s", w, label)
+			}
+			values[id] = w
+		}
+	case proto.EMode_E_SEED_SET:
+		for _, label := range e.GetNodes() {
+			id, ok := resolve(label)
+			if !ok {
+				return fmt.Errorf("Seed node %s is not in the graph", label)
+			}
+			values[id] = 1
+		}
+	default:
+		return fmt.Errorf("Unknown E mode %s", e.GetMode())
+	}
+	total := 0.0
+	for _, id := range sortedIds(graph) {
+		total += values[id]
+	}
+	if total == 0 {
+		return fmt.Errorf("E vector is zero for every node")
+	}
+	for id, u := range graph {
+		u.E = values[id] / total
+	}
+	return nil
+}
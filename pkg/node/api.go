@@ -3,47 +3,184 @@ package node
 import (
 	"context"
 	"fmt"
+	"io"
+	"math/rand"
+	"time"
 
-	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/lioia/distributed-pagerank/pkg/graph"
+	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type ApiServerImpl struct {
-	Node       *Node             // Node state
-	Ranks      chan *proto.Ranks // Client state
-	Iterations chan int32        // Client state
+	Node *Node // Node state
 	proto.UnimplementedAPIServer
 }
 
-func (s *ApiServerImpl) GraphUpload(_ context.Context, in *proto.Configuration) (*emptypb.Empty, error) {
+func (s *ApiServerImpl) GraphUpload(_ context.Context, in *proto.Configuration) (*wrapperspb.StringValue, error) {
+	if in.GetGraph() == "" {
+		// Random graph (or no graph at all)
+		return s.submit(in, nil)
+	}
+	return s.submit(in, func(options graph.LoadOptions) (map[int32]*proto.GraphNode, []string, error) {
+		// Graph URL was provided, downloading and parsing the graph
+		return graph.LoadGraphResource(in.GetGraph(), options)
+	})
+}
+
+func (s *ApiServerImpl) UploadGraph(stream proto.API_UploadGraphServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	in := first.GetConfiguration()
+	if in == nil {
+		return fmt.Errorf("Expecting the configuration as first message")
+	}
+	// The graph is parsed while the chunks are received
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				writer.Close()
+				return
+			}
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if _, err := writer.Write(chunk.GetData()); err != nil {
+				// Stopped reading (invalid file)
+				return
+			}
+		}
+	}()
+	job, err := s.submit(in, func(options graph.LoadOptions) (map[int32]*proto.GraphNode, []string, error) {
+		return graph.LoadGraphStream(reader, in.GetGraph(), "", options)
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(job)
+}
+
+// Load the graph (if load is set, otherwise generate the random graph)
+// and queue the computation
+func (s *ApiServerImpl) submit(in *proto.Configuration, load func(graph.LoadOptions) (map[int32]*proto.GraphNode, []string, error)) (*wrapperspb.StringValue, error) {
 	var err error
+	// Every random value of the job is generated from the same seed
+	seed := time.Now().UnixNano()
+	if state := in.GetRandomGraph(); state != nil && state.Seed != nil {
+		seed = state.GetSeed()
+	}
+	r := rand.New(rand.NewSource(seed))
 	g := make(map[int32]*proto.GraphNode)
-	if state := in.GetGraph(); state != "" {
-		// Graph URL was provided, downloading and parsing the graph
-		g, err = graph.LoadGraphResource(state)
+	var labels []string
+	if load != nil {
+		g, labels, err = load(graph.LoadOptions{
+			MaxInvalidLines: int(in.MaxInvalidLines),
+			Weighted:        in.Weighted,
+			Format:          in.Format,
+		})
 		if err != nil {
-			return &emptypb.Empty{}, fmt.Errorf("Failed to load graph: %v", err)
+			return &wrapperspb.StringValue{}, fmt.Errorf("Failed to load graph: %v", err)
 		}
-
 	} else if state := in.GetRandomGraph(); state != nil {
 		// Random graph config was provided, generaring the graph
-		g = graph.Generate(state.NumberOfNodes, state.MaxNumberOfEdges)
+		g, err = graph.GenerateModel(state, r)
+		if err != nil {
+			return &wrapperspb.StringValue{}, fmt.Errorf("Failed to generate graph: %v", err)
+		}
+	}
+	if len(g) == 0 {
+		return &wrapperspb.StringValue{}, fmt.Errorf("Graph is empty")
+	}
+	if err := graph.SetE(g, labels, in.E, r); err != nil {
+		return &wrapperspb.StringValue{}, fmt.Errorf("Invalid E vector: %v", err)
 	}
-	s.Node.State.Client = in.Connection
-	s.Node.State.C = in.C
-	s.Node.State.Threshold = in.Threshold
-	s.Node.State.Graph = g
-	return &emptypb.Empty{}, nil
+	// Queue the computation; the master will start it once the previous jobs are completed
+	job := s.Node.Scheduler.Submit(&proto.State{
+		C:            in.C,
+		Threshold:    in.Threshold,
+		Graph:        g,
+		Partitioning: in.Partitioning,
+		Dangling:     in.Dangling,
+		Seed:         seed,
+		Labels:       labels,
+	})
+	utils.ServerLog("GraphUpload: queued job %s", job.Id)
+	return wrapperspb.String(job.Id), nil
 }
 
-func (s *ApiServerImpl) Results(_ context.Context, in *proto.Ranks) (*emptypb.Empty, error) {
-	s.Ranks <- in
-	return &emptypb.Empty{}, nil
+// Send the current progress of the job, then every update until the final ranks
+func (s *ApiServerImpl) WatchJob(in *wrapperspb.StringValue, stream proto.API_WatchJobServer) error {
+	job := s.Node.Scheduler.Get(in.Value)
+	if job == nil {
+		return fmt.Errorf("Unknown job %s", in.Value)
+	}
+	events, stop := job.watch()
+	defer stop()
+	progress := job.progress()
+	if err := stream.Send(progress); err != nil || finished(progress.Status) {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// Job completed (or cancelled): the final event was already sent
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
 }
 
-func (s *ApiServerImpl) Iteration(_ context.Context, in *wrappers.Int32Value) (*emptypb.Empty, error) {
-	s.Iterations <- in.Value
-	return &emptypb.Empty{}, nil
+func (s *ApiServerImpl) GetJob(_ context.Context, in *wrapperspb.StringValue) (*proto.JobInfo, error) {
+	job := s.Node.Scheduler.Get(in.Value)
+	if job == nil {
+		return nil, fmt.Errorf("Unknown job %s", in.Value)
+	}
+	return job.info(), nil
+}
+
+func (s *ApiServerImpl) ListJobs(_ context.Context, _ *emptypb.Empty) (*proto.JobList, error) {
+	list := &proto.JobList{}
+	for _, job := range s.Node.Scheduler.List() {
+		list.Jobs = append(list.Jobs, job.info())
+	}
+	return list, nil
+}
+
+func (s *ApiServerImpl) CancelJob(_ context.Context, in *wrapperspb.StringValue) (*proto.JobInfo, error) {
+	job, err := s.Node.Scheduler.Cancel(in.Value)
+	if err != nil {
+		return nil, err
+	}
+	utils.ServerLog("CancelJob: cancelling job %s", job.Id)
+	return job.info(), nil
+}
+
+func (s *ApiServerImpl) GetRanks(_ context.Context, in *proto.RanksRequest) (*proto.RanksPage, error) {
+	job := s.Node.Scheduler.Get(in.Job)
+	if job == nil {
+		return nil, fmt.Errorf("Unknown job %s", in.Job)
+	}
+	return job.ranks(in)
+}
+
+func (s *ApiServerImpl) GetEdges(_ context.Context, in *proto.EdgesRequest) (*proto.EdgesPage, error) {
+	job := s.Node.Scheduler.Get(in.Job)
+	if job == nil {
+		return nil, fmt.Errorf("Unknown job %s", in.Job)
+	}
+	return job.edges(in)
 }
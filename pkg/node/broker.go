@@ -0,0 +1,160 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/pkg/utils"
+	"github.com/lioia/distributed-pagerank/proto"
+	amqp "github.com/rabbitmq/amqp091-go"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Message transport between master (jobs) and workers (results)
+type Broker interface {
+	// Master to Workers: add a job to the work queue
+	PublishWork(job *proto.Job) error
+	// Worker: receive jobs from the work queue (consumer used as an identifier)
+	ConsumeWork(consumer string) (<-chan Delivery[*proto.Job], error)
+	// Worker to Master: add a result to the result queue
+	PublishResult(result *proto.Result) error
+	// Master: receive results from the result queue
+	ConsumeResult() (<-chan Delivery[*proto.Result], error)
+	// Stop delivering jobs to the consumer
+	Cancel(consumer string) error
+	// Remove every message from both queues
+	Purge() error
+	// Work and result queue names
+	Queues() (string, string)
+}
+
+// Message received from a Broker
+type Delivery[T protobuf.Message] struct {
+	Value T            // Decoded message
+	Ack   func() error // Message was processed: remove it from the queue
+	Nack  func() error // Message was not processed: re-add it to the queue
+}
+
+// RabbitMQ implementation of Broker
+type RabbitBroker struct {
+	Conn    *amqp.Connection
+	Channel *amqp.Channel
+	Work    *amqp.Queue
+	Result  *amqp.Queue
+}
+
+// Open a channel on the connection and declare work and result queues
+func NewRabbitBroker(conn *amqp.Connection, work, result string) (*RabbitBroker, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	workQueue, err := utils.DeclareQueue(work, ch)
+	if err != nil {
+		return nil, err
+	}
+	resultQueue, err := utils.DeclareQueue(result, ch)
+	if err != nil {
+		return nil, err
+	}
+	return &RabbitBroker{
+		Conn:    conn,
+		Channel: ch,
+		Work:    &workQueue,
+		Result:  &resultQueue,
+	}, nil
+}
+
+func (b *RabbitBroker) PublishWork(job *proto.Job) error {
+	return b.publish(b.Work.Name, job)
+}
+
+func (b *RabbitBroker) ConsumeWork(consumer string) (<-chan Delivery[*proto.Job], error) {
+	msgs, err := b.consume(b.Work.Name, consumer)
+	if err != nil {
+		return nil, err
+	}
+	return rabbitDeliveries(msgs, func() *proto.Job { return &proto.Job{} }), nil
+}
+
+func (b *RabbitBroker) PublishResult(result *proto.Result) error {
+	return b.publish(b.Result.Name, result)
+}
+
+func (b *RabbitBroker) ConsumeResult() (<-chan Delivery[*proto.Result], error) {
+	msgs, err := b.consume(b.Result.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	return rabbitDeliveries(msgs, func() *proto.Result { return &proto.Result{} }), nil
+}
+
+func (b *RabbitBroker) Cancel(consumer string) error {
+	return b.Channel.Cancel(consumer, true)
+}
+
+func (b *RabbitBroker) Purge() error {
+	if _, err := b.Channel.QueuePurge(b.Work.Name, true); err != nil {
+		return err
+	}
+	_, err := b.Channel.QueuePurge(b.Result.Name, true)
+	return err
+}
+
+func (b *RabbitBroker) Queues() (string, string) {
+	return b.Work.Name, b.Result.Name
+}
+
+func (b *RabbitBroker) publish(queue string, msg protobuf.Message) error {
+	data, err := protobuf.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return b.Channel.PublishWithContext(ctx,
+		"",
+		queue, // routing key
+		false, // mandatory
+		false,
+		amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/x-protobuf",
+			Body:         data,
+		})
+}
+
+func (b *RabbitBroker) consume(queue, consumer string) (<-chan amqp.Delivery, error) {
+	return b.Channel.Consume(
+		queue,    // queue
+		consumer, // consumer
+		false,    // auto-ack
+		false,    // exclusive
+		false,    // no-local
+		false,    // no-wait
+		nil,      // args
+	)
+}
+
+// Decode AMQP deliveries into protobuf messages
+func rabbitDeliveries[T protobuf.Message](msgs <-chan amqp.Delivery, empty func() T) <-chan Delivery[T] {
+	deliveries := make(chan Delivery[T])
+	go func() {
+		defer close(deliveries)
+		for msg := range msgs {
+			msg := msg
+			nack := func() error { return msg.Nack(false, true) }
+			value := empty()
+			if err := protobuf.Unmarshal(msg.Body, value); err != nil {
+				utils.FailOnNack(nack, err)
+				continue
+			}
+			deliveries <- Delivery[T]{
+				Value: value,
+				Ack:   func() error { return msg.Ack(false) },
+				Nack:  nack,
+			}
+		}
+	}()
+	return deliveries
+}
@@ -0,0 +1,190 @@
+package node
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Checkpoints of the running jobs are saved in the store, one directory
+// (job ID with this suffix) per job:
+//   - state.pb: graph and parameters of the job (saved when the job starts)
+//   - checkpoint.json: metadata (submission time)
+//   - ranks.bin: last checkpoint (iteration int32, delta float64, then
+//     id int32, rank float64 for every node; little endian, sorted by id)
+const checkpointSuffix = ".checkpoint"
+
+// Metadata of a checkpointed job
+type checkpointJob struct {
+	Job       string    `json:"job"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// Job state as of its last checkpoint
+type Checkpoint struct {
+	State     *proto.State
+	Delta     float64
+	Submitted time.Time
+}
+
+func (s *Store) checkpointDir(id string) string {
+	return filepath.Join(s.Dir, id+checkpointSuffix)
+}
+
+// Save the graph of a job being started (if it was not already saved)
+// and the current ranks as its first checkpoint
+func (s *Store) StartCheckpoint(job *Job) error {
+	dir := s.checkpointDir(job.Id)
+	if _, err := os.Stat(filepath.Join(dir, "state.pb")); err == nil {
+		return s.Checkpoint(job)
+	}
+	job.mu.Lock()
+	// Other nodes of the network are not part of the job
+	state := &proto.State{
+		Graph:        job.State.Graph,
+		C:            job.State.C,
+		Threshold:    job.State.Threshold,
+		Iteration:    job.State.Iteration,
+		Job:          job.State.Job,
+		Partitioning: job.State.Partitioning,
+		Dangling:     job.State.Dangling,
+		Seed:         job.State.Seed,
+		Labels:       job.State.Labels,
+	}
+	submitted := job.Submitted
+	job.mu.Unlock()
+	data, err := protobuf.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.Dir, fmt.Sprintf(".%s.tmp", job.Id+checkpointSuffix))
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := writeFile(filepath.Join(tmp, "state.pb"), data); err != nil {
+		return err
+	}
+	err = writeJSON(filepath.Join(tmp, "checkpoint.json"), checkpointJob{Job: job.Id, Submitted: submitted})
+	if err != nil {
+		return err
+	}
+	if err := replaceDir(tmp, dir); err != nil {
+		return err
+	}
+	return s.Checkpoint(job)
+}
+
+// Save the ranks and the iteration of the job
+// (written in a temporary file, then renamed)
+func (s *Store) Checkpoint(job *Job) error {
+	job.mu.Lock()
+	ids := make([]int32, 0, len(job.State.Graph))
+	for id := range job.State.Graph {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	data := make([]byte, 0, 12+12*len(ids))
+	data = binary.LittleEndian.AppendUint32(data, uint32(job.State.Iteration))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(job.Delta))
+	for _, id := range ids {
+		data = binary.LittleEndian.AppendUint32(data, uint32(id))
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(job.State.Graph[id].Rank))
+	}
+	job.mu.Unlock()
+	dir := s.checkpointDir(job.Id)
+	path := filepath.Join(dir, "ranks.bin")
+	if err := writeFile(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Remove the checkpoint of a job that is not computed anymore
+func (s *Store) RemoveCheckpoint(id string) error {
+	return os.RemoveAll(s.checkpointDir(id))
+}
+
+// Checkpointed jobs (submission order); unreadable checkpoints are returned as errors
+func (s *Store) Checkpoints() ([]*Checkpoint, []error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var checkpoints []*Checkpoint
+	var errs []error
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), checkpointSuffix)
+		if !entry.IsDir() || !ok || strings.HasPrefix(id, ".") {
+			continue
+		}
+		checkpoint, err := s.LoadCheckpoint(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Job %s: %v", id, err))
+			continue
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Submitted.Before(checkpoints[j].Submitted)
+	})
+	return checkpoints, errs
+}
+
+// Job state of the last checkpoint of a job
+func (s *Store) LoadCheckpoint(id string) (*Checkpoint, error) {
+	dir := s.checkpointDir(id)
+	var meta checkpointJob
+	if err := readJSON(filepath.Join(dir, "checkpoint.json"), &meta); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "state.pb"))
+	if err != nil {
+		return nil, err
+	}
+	state := &proto.State{}
+	if err := protobuf.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Job != id {
+		return nil, fmt.Errorf("State belongs to job %s", state.Job)
+	}
+	checkpoint := &Checkpoint{State: state, Submitted: meta.Submitted}
+	data, err = os.ReadFile(filepath.Join(dir, "ranks.bin"))
+	if os.IsNotExist(err) {
+		// Stopped before the first checkpoint: starting from the initial ranks
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 12+12*len(state.Graph) {
+		return nil, fmt.Errorf("Corrupted checkpoint of job %s", id)
+	}
+	state.Iteration = int32(binary.LittleEndian.Uint32(data))
+	checkpoint.Delta = math.Float64frombits(binary.LittleEndian.Uint64(data[4:]))
+	for i := 12; i < len(data); i += 12 {
+		node, ok := state.Graph[int32(binary.LittleEndian.Uint32(data[i:]))]
+		if !ok {
+			return nil, fmt.Errorf("Corrupted checkpoint of job %s", id)
+		}
+		node.Rank = math.Float64frombits(binary.LittleEndian.Uint64(data[i+4:]))
+	}
+	for _, u := range state.Graph {
+		for j, v := range u.InLinks {
+			v.Rank = state.Graph[j].Rank
+		}
+	}
+	return checkpoint, nil
+}
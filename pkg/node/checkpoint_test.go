@@ -0,0 +1,144 @@
+package node
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	var s Scheduler
+	if err := s.Open(t.TempDir(), true); err != nil {
+		t.Fatal(err)
+	}
+	job := s.Submit(testState())
+	// Submitted jobs are checkpointed with their initial ranks
+	checkpoint, err := s.Store().LoadCheckpoint(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.State.Iteration != 0 || len(checkpoint.State.Graph) != 3 {
+		t.Fatalf("unexpected first checkpoint %v", checkpoint.State)
+	}
+	ranks := map[int32]float64{0: 0.5, 1: 0.3, 2: 0.2}
+	for id, rank := range ranks {
+		job.State.Graph[id].Rank = rank
+	}
+	job.iterate(0.25)
+	job.iterate(0.125)
+	if err := s.Store().Checkpoint(job); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err = s.Store().LoadCheckpoint(job.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.State.Iteration != 2 || checkpoint.Delta != 0.125 || !checkpoint.Submitted.Equal(job.Submitted) {
+		t.Errorf("unexpected checkpoint (iteration %d, delta %f, submitted %v)",
+			checkpoint.State.Iteration, checkpoint.Delta, checkpoint.Submitted)
+	}
+	for id, u := range checkpoint.State.Graph {
+		if u.Rank != ranks[id] {
+			t.Errorf("node %d: expected rank %f, got %f", id, ranks[id], u.Rank)
+		}
+		for j, v := range u.InLinks {
+			if v.Rank != ranks[j] {
+				t.Errorf("in-link %d -> %d: expected rank %f, got %f", j, id, ranks[j], v.Rank)
+			}
+		}
+	}
+	// Corrupted rank vector
+	path := s.Store().checkpointDir(job.Id) + "/ranks.bin"
+	if err := os.WriteFile(path, []byte{1, 2, 3}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Store().LoadCheckpoint(job.Id); err == nil {
+		t.Errorf("expected an error for a corrupted checkpoint")
+	}
+}
+
+func TestMasterResume(t *testing.T) {
+	dir := t.TempDir()
+	var previous Scheduler
+	if err := previous.Open(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	var submitted []*Job
+	for i := 0; i < 4; i++ {
+		submitted = append(submitted, previous.Submit(testState()))
+	}
+	// Running job, checkpointed at iteration 5
+	running := previous.Next()
+	for i := 0; i < 5; i++ {
+		running.iterate(0.5)
+	}
+	if err := previous.Store().Checkpoint(running); err != nil {
+		t.Fatal(err)
+	}
+	// Completed job, stopped before its checkpoint was removed
+	completed := previous.Next()
+	completed.finish(proto.JobStatus_JOB_COMPLETED, &proto.Ranks{Job: completed.Id, Ranks: map[int32]float64{0: 1}})
+	if err := previous.Save(completed); err != nil {
+		t.Fatal(err)
+	}
+	// Cancelled job: its checkpoint is removed
+	if _, err := previous.Cancel(submitted[3].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart: the other jobs are queued again in submission order
+	n := &Node{State: &proto.State{Job: running.Id}}
+	if err := n.Scheduler.Open(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	masterResume(n)
+	expected := []*Job{running, submitted[2]}
+	for _, e := range expected {
+		job := n.Scheduler.Next()
+		if job == nil || job.Id != e.Id {
+			t.Fatalf("expected job %s, got %v", e.Id, job)
+		}
+		if job.State.Iteration != e.State.Iteration || !job.Submitted.Equal(e.Submitted) {
+			t.Errorf("job %s: expected iteration %d, got %d", job.Id, e.State.Iteration, job.State.Iteration)
+		}
+	}
+	if job := n.Scheduler.Next(); job != nil {
+		t.Errorf("unexpected job %s", job.Id)
+	}
+	if _, err := os.Stat(n.Scheduler.Store().checkpointDir(completed.Id)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint of the completed job was not removed")
+	}
+	if status := n.Scheduler.Get(completed.Id).info().Status; status != proto.JobStatus_JOB_COMPLETED {
+		t.Errorf("expected completed job, got %s", statusName(status))
+	}
+}
+
+func TestSchedulerRequeue(t *testing.T) {
+	var s Scheduler
+	first := s.Submit(testState())
+	last := s.Submit(testState())
+	checkpoint := func(id string, submitted time.Time) *Checkpoint {
+		state := testState()
+		state.Job = id
+		state.Iteration = 7
+		return &Checkpoint{State: state, Delta: 0.5, Submitted: submitted}
+	}
+	// Submitted before the other jobs, between them and after them
+	oldest := s.Requeue(checkpoint("oldest", first.Submitted.Add(-time.Second)))
+	middle := s.Requeue(checkpoint("middle", first.Submitted.Add(last.Submitted.Sub(first.Submitted)/2)))
+	newest := s.Requeue(checkpoint("newest", last.Submitted.Add(time.Second)))
+	if s.Requeue(checkpoint(first.Id, first.Submitted)) != nil {
+		t.Errorf("job %s queued twice", first.Id)
+	}
+	for _, expected := range []*Job{oldest, first, middle, last, newest} {
+		job := s.Next()
+		if job != expected {
+			t.Fatalf("expected job %s, got %v", expected.Id, job)
+		}
+	}
+	if middle.State.Iteration != 7 || middle.Delta != 0.5 {
+		t.Errorf("progress of job %s not restored", middle.Id)
+	}
+}
@@ -1,38 +1,48 @@
 package node
 
 import (
-	"context"
 	"fmt"
 	"math"
 	"net"
-	"sync"
 	"time"
 
 	"github.com/lioia/distributed-pagerank/pkg/graph"
 	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
+	gonanoid "github.com/matoous/go-nanoid/v2"
 
-	amqp "github.com/rabbitmq/amqp091-go"
 	"google.golang.org/grpc"
-	protobuf "google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func (n *Node) masterUpdate() {
 	status := make(chan bool)
+	masterOpenStore(n)
 	go masterInitializeAPIServer(n)
 	go masterReadQueue(n, status)
 	// wait for queue registration
 	<-status
+	masterResume(n)
 	for {
-		switch n.Phase {
+		masterStep(n)
+		// Update every 500ms
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Advance the current job (or start the next one) by one phase, if possible
+func masterStep(n *Node) {
+	if n.Job == nil {
+		masterSchedule(n)
+	} else if n.Job.cancelled() {
+		masterCancel(n)
+	} else {
+		switch n.Job.Phase {
 		case Wait:
 			err := masterWait(n)
 			utils.FailOnError("Could not execute Wait phase", err)
 		case Map:
-			if n.Jobs == n.Responses {
-				n.Responses = 0
-				n.Phase = Collect
+			if n.Job.completed() {
+				n.Job.setPhase(Collect)
 				utils.NodeLog("master", "Completed Map phase")
 				break
 			}
@@ -40,108 +50,246 @@ func (n *Node) masterUpdate() {
 			err := masterCollect(n)
 			utils.FailOnError("Could not execute Collect phase", err)
 		case Reduce:
-			if n.Jobs == n.Responses {
-				n.Responses = 0
-				n.Phase = Convergence
+			if n.Job.completed() {
+				n.Job.setPhase(Convergence)
 				utils.NodeLog("master", "Completed Reduce phase")
 				break
 			}
 		case Convergence:
 			masterConvergence(n)
 		}
-		// Update every 500ms
-		time.Sleep(500 * time.Millisecond)
 	}
 }
 
-func masterWait(n *Node) error {
-	if n.State.Graph != nil && len(n.State.Graph) > 0 {
-		// A graph was loaded (from configuration or previous iteration)
+// Restore the jobs completed before a restart (saved in STORE_DIR);
+// the running job is checkpointed every CHECKPOINT_INTERVAL iterations
+// (pending jobs when they are submitted)
+func masterOpenStore(n *Node) {
+	dir := utils.ReadStringEnvVarOr("STORE_DIR", "store")
+	interval := int32(utils.ReadIntEnvVarOr("CHECKPOINT_INTERVAL", 10))
+	if err := n.Scheduler.Open(dir, interval > 0); err != nil {
+		utils.NodeLog("master", "[WARN] Could not open store %s: %v", dir, err)
+		return
+	}
+	n.Checkpoint = interval
+}
 
-		// No other node in the network -> calculating PageRank on this node
-		if len(n.State.Others) == 0 {
-			n.State.Iteration = graph.SingleNodePageRank(n.State.Graph, n.State.C, n.State.Threshold)
-			fmt.Printf("Computation finished. Sending results to client\n")
-			masterSendRanksToClient(n)
-			fmt.Println("Waiting for new computation")
-			// Node Reset
-			n.State = &proto.State{
-				Graph:     nil,
-				C:         0.0,
-				Threshold: 0.0,
+// Queue again the jobs checkpointed before a restart; an elected master
+// resumes the job of the previous master only if STORE_DIR is shared
+func masterResume(n *Node) {
+	n.mu.Lock()
+	// Shared state of the previous master (its job is resumed from the checkpoint)
+	previous := n.State.Job
+	n.State = &proto.State{Others: n.State.Others}
+	n.mu.Unlock()
+	store := n.Scheduler.Store()
+	if store == nil {
+		if previous != "" {
+			utils.NodeLog("master", "[WARN] Could not resume job %s: no store", previous)
+		}
+		return
+	}
+	checkpoints, errs := store.Checkpoints()
+	for _, err := range errs {
+		utils.NodeLog("master", "[WARN] Could not resume job: %v", err)
+	}
+	for _, checkpoint := range checkpoints {
+		job := n.Scheduler.Requeue(checkpoint)
+		if job == nil {
+			// Completed before the checkpoint was removed
+			continue
+		}
+		fmt.Printf("Resuming job %s from iteration %d\n", job.Id, checkpoint.State.Iteration)
+	}
+	if previous != "" && n.Scheduler.Get(previous) == nil {
+		utils.NodeLog("master", "[WARN] Could not resume job %s: no checkpoint in %s", previous, store.Dir)
+	}
+}
+
+// Save the graph of the job being started (first checkpoint)
+func masterStartCheckpoint(n *Node) {
+	if store := n.Scheduler.Store(); store != nil && n.Checkpoint > 0 {
+		if err := store.StartCheckpoint(n.Job); err != nil {
+			utils.NodeLog("master", "[WARN] Could not checkpoint job %s: %v", n.Job.Id, err)
+		}
+	}
+}
+
+// Whether the ranks of this iteration are checkpointed (every n.Checkpoint iterations)
+func masterCheckpointDue(n *Node) bool {
+	return n.Scheduler.Store() != nil && n.Checkpoint > 0 && n.State.Iteration%n.Checkpoint == 0
+}
+
+// Save the ranks of the job (if a checkpoint is due)
+func masterCheckpoint(n *Node) {
+	if !masterCheckpointDue(n) {
+		return
+	}
+	store := n.Scheduler.Store()
+	if err := store.Checkpoint(n.Job); err != nil {
+		utils.NodeLog("master", "[WARN] Could not checkpoint job %s: %v", n.Job.Id, err)
+		return
+	}
+	utils.NodeLog("master", "Checkpoint of job %s (iteration %d)", n.Job.Id, n.State.Iteration)
+}
+
+// Start the next queued job (if there is one)
+func masterSchedule(n *Node) {
+	job := n.Scheduler.Next()
+	if job == nil {
+		// Wait for configuration from client
+		return
+	}
+	fmt.Printf("Starting job %s\n", job.Id)
+	// The job state becomes the state shared with the workers
+	n.mu.Lock()
+	job.State.Others = n.State.Others
+	n.State = job.State
+	n.Job = job
+	n.mu.Unlock()
+	masterStartCheckpoint(n)
+}
+
+// Stop the current job: its sub-jobs and results are removed from the queues
+// (results still being computed are dropped as stale)
+func masterCancel(n *Node) {
+	fmt.Printf("Cancelling job %s\n", n.Job.Id)
+	if err := n.Broker.Purge(); err != nil {
+		utils.NodeLog("master", "[WARN] Could not purge queues: %v", err)
+	}
+	n.Job.startPhase(Wait, 0)
+	n.Job.clearData()
+	n.Job.finish(proto.JobStatus_JOB_CANCELLED, nil)
+	masterReset(n)
+}
+
+// Reset master state after the current job is completed
+func masterReset(n *Node) {
+	fmt.Println("Waiting for new computation")
+	if store := n.Scheduler.Store(); store != nil {
+		if err := store.RemoveCheckpoint(n.Job.Id); err != nil {
+			utils.NodeLog("master", "[WARN] Could not remove checkpoint of job %s: %v", n.Job.Id, err)
+		}
+	}
+	n.Job.release()
+	n.mu.Lock()
+	n.State = &proto.State{Others: n.State.Others}
+	n.Job = nil
+	n.mu.Unlock()
+}
+
+func masterWait(n *Node) error {
+	// No other node in the network -> calculating PageRank on this node
+	if len(n.State.Others) == 0 {
+		// Resumed jobs continue from their iteration
+		delta := graph.SingleNodePageRank(n.State.Graph, n.State.C, n.State.Threshold, n.State.Dangling, n.State.Iteration, func(delta float64, storeRanks func()) bool {
+			// The progress of the job is updated after every iteration
+			n.Job.iterate(delta)
+			n.Job.notify()
+			if masterCheckpointDue(n) {
+				storeRanks()
+				masterCheckpoint(n)
 			}
-			n.Phase = Wait
-			n.Jobs = 0
-			n.Responses = 0
-			n.Data = sync.Map{}
-			utils.NodeLog("master", "Completed Wait phase on single node")
+			return !n.Job.cancelled()
+		})
+		if n.Job.cancelled() {
+			masterCancel(n)
 			return nil
 		}
-		fmt.Println("Starting computation")
-		masterSendIterationToClient(n)
+		n.Job.setDelta(delta)
+		fmt.Printf("Computation finished. Sending results to client\n")
+		masterFinishJob(n)
+		utils.NodeLog("master", "Completed Wait phase on single node")
+		masterReset(n)
+		return nil
+	}
+	fmt.Println("Starting computation")
+	n.Job.notify()
+	if !n.Job.Distributed {
+		// New job: the workers receive its parameters (the partitions and
+		// the ranks they need are sent with the Map jobs)
 		go masterSendUpdateToWorkers(n)
-		err := masterWriteQueue(n, func(m map[int32]*proto.GraphNode) *proto.Job {
-			mapData := make(map[int32]*proto.Map)
-			dummyReduce := make(map[int32]*proto.Reduce)
-			for id, v := range m {
-				mapData[id] = &proto.Map{InLinks: v.InLinks}
+	}
+	err := masterWriteQueue(n, Map, func(nodes []int32) *proto.Job {
+		mapData := make(map[int32]*proto.Map)
+		dummyReduce := make(map[int32]*proto.Reduce)
+		ranks := make(map[int32]float64)
+		for _, id := range nodes {
+			u := n.State.Graph[id]
+			if !n.Job.Distributed {
+				// First iteration: the partition is sent to (and kept by) the workers
+				mapData[id] = &proto.Map{InLinks: workerLinks(u.InLinks)}
 			}
-			return &proto.Job{
-				Type:       0,
-				MapData:    mapData,
-				ReduceData: dummyReduce,
+			for j := range u.InLinks {
+				ranks[j] = n.State.Graph[j].Rank
 			}
-		})
-		if err != nil {
-			return err
 		}
-		n.Phase = Map
-		utils.NodeLog("master", "Completed Wait phase; switch to Map phase (%d jobs)", n.Jobs)
-		return nil
+		return &proto.Job{
+			Type:         0,
+			MapData:      mapData,
+			ReduceData:   dummyReduce,
+			Ranks:        ranks,
+			Partitioning: n.Job.Partitioning,
+		}
+	})
+	if err != nil {
+		return err
 	}
-	// Wait for configuration from client
+	n.Job.Distributed = true
+	utils.NodeLog("master", "Completed Wait phase; switch to Map phase (%d jobs)", n.Job.SubJobs)
 	return nil
 }
 
 func masterCollect(n *Node) error {
 	if len(n.State.Others) == 0 {
 		// Go to wait and call single node pagerank
-		n.Phase = Wait
+		n.Job.setPhase(Wait)
 		return nil
 	}
 	data := make(map[int32]float64)
-	n.Data.Range(func(key, value any) bool {
+	n.Job.Data.Range(func(key, value any) bool {
 		data[key.(int32)] = value.(float64)
 		return true
 	})
-	n.Data = sync.Map{}
-	err := masterWriteQueue(n, func(m map[int32]*proto.GraphNode) *proto.Job {
+	n.Job.clearData()
+	// Add the rank of the dangling nodes (it needs the whole graph)
+	if n.Job.Graph == nil {
+		n.Job.Graph = graph.NewCSR(n.State.Graph)
+	}
+	g := n.Job.Graph
+	rank := make([]float64, len(g.Ids))
+	sum := make([]float64, len(g.Ids))
+	for i, id := range g.Ids {
+		rank[i] = n.State.Graph[id].Rank
+		sum[i] = data[id]
+	}
+	g.AddDangling(n.State.Dangling, rank, sum)
+	err := masterWriteQueue(n, Reduce, func(nodes []int32) *proto.Job {
 		dummyMap := make(map[int32]*proto.Map)
 		reduce := make(map[int32]*proto.Reduce)
-		for id, v := range m {
+		for _, id := range nodes {
 			reduce[id] = &proto.Reduce{
-				Sum: data[id],
-				E:   v.E,
+				Sum: sum[g.Index[id]],
+				E:   n.State.Graph[id].E,
 			}
 		}
 		return &proto.Job{
 			Type:       1,
 			ReduceData: reduce,
 			MapData:    dummyMap,
+			C:          n.State.C,
 		}
 	})
 	if err != nil {
 		return err
 	}
-	// Switch to Reduce phase
-	n.Phase = Reduce
-	utils.NodeLog("master", "Completed Collect phase; switch to Reduce phase (%d jobs)", n.Jobs)
+	utils.NodeLog("master", "Completed Collect phase; switch to Reduce phase (%d jobs)", n.Job.SubJobs)
 	return nil
 }
 
 func masterConvergence(n *Node) {
 	var convergence float64
-	n.Data.Range(func(key, value any) bool {
+	n.Job.Data.Range(func(key, value any) bool {
 		id := key.(int32)
 		newRank := value.(float64)
 		oldRank := n.State.Graph[id].Rank
@@ -166,63 +314,54 @@ func masterConvergence(n *Node) {
 			n.State.Graph[id].Rank /= rankSum
 		}
 		fmt.Printf("Computation finished. Sending results to client\n")
-		masterSendRanksToClient(n)
-		fmt.Println("Waiting for new computation")
-		// Node Reset
-		n.State = &proto.State{
-			Graph:     nil,
-			C:         0.0,
-			Threshold: 0.0,
-		}
-		n.Phase = Wait
-		n.Jobs = 0
-		n.Responses = 0
-
+		n.Job.setDelta(convergence)
+		masterFinishJob(n)
+		masterReset(n)
 	} else {
 		// Does not converge -> iterate
 		utils.NodeLog("master", "Convergence check failed (%f)", convergence)
 		// Start new computation with updated pagerank values
-		n.Phase = Wait
-		n.State.Iteration += 1
+		n.Job.clearData()
+		n.Job.iterate(convergence)
+		masterCheckpoint(n)
 	}
-	n.Data = sync.Map{}
 }
 
-func masterSendIterationToClient(n *Node) {
-	client, err := utils.ApiCall(n.State.Client)
-	utils.FailOnError("Failed to create connection to the client", err)
-	defer client.Close()
-	_, err = client.Client.Iteration(
-		client.Ctx,
-		wrapperspb.Int32(n.State.Iteration),
-	)
-	utils.FailOnError("Failed to send client results", err)
-}
-
-func masterSendRanksToClient(n *Node) {
+// Store the final ranks in the job (served to the clients with GetRanks)
+// and save them, so that they are available after a restart
+func masterFinishJob(n *Node) {
 	status := "Failed to converge after 100 iterations"
 	if n.State.Iteration < 100 {
 		status = fmt.Sprintf("Converged after %d iterations", n.State.Iteration)
 	}
-	dot := graph.ConvertToDot(n.State.Graph)
 	results := &proto.Ranks{
-		Ranks:    make(map[int32]float64),
-		Master:   n.APIConnection,
-		Status:   status,
-		DotGraph: dot,
+		Ranks:  make(map[int32]float64),
+		Master: n.APIConnection,
+		Status: status,
+		Job:    n.Job.Id,
+		Seed:   n.State.Seed,
+		Edges:  graph.Edges(n.State.Graph),
 	}
 	for id, v := range n.State.Graph {
 		results.Ranks[id] = v.Rank
 	}
-	client, err := utils.ApiCall(n.State.Client)
-	utils.FailOnError("Failed to create connection to the client", err)
-	defer client.Close()
-	_, err = client.Client.Results(client.Ctx, results)
-	utils.FailOnError("Failed to send client results", err)
+	if len(n.State.Labels) > 0 {
+		results.Labels = make(map[int32]string, len(n.State.Labels))
+		for id := range n.State.Graph {
+			results.Labels[id] = n.State.Labels[id]
+		}
+	}
+	n.Job.finish(proto.JobStatus_JOB_COMPLETED, results)
+	if err := n.Scheduler.Save(n.Job); err != nil {
+		utils.NodeLog("master", "[WARN] Could not save job %s: %v", n.Job.Id, err)
+	}
 }
 
-// Master send state to all workers
+// Master send the parameters of the job to all workers
 func masterSendUpdateToWorkers(n *Node) {
+	n.mu.Lock()
+	state := sharedState(n.State)
+	n.mu.Unlock()
 	for i, v := range n.State.Others {
 		worker, err := utils.NodeCall(v)
 		if err != nil {
@@ -231,7 +370,7 @@ func masterSendUpdateToWorkers(n *Node) {
 			continue
 		}
 		defer worker.Close()
-		_, err = worker.Client.StateUpdate(worker.Ctx, n.State)
+		_, err = worker.Client.StateUpdate(worker.Ctx, state)
 		if err != nil {
 			utils.ServerLog("[WARN] Worker %s crashed", v)
 			delete(n.State.Others, i)
@@ -277,84 +416,91 @@ func masterInitializeAPIServer(n *Node) {
 	utils.FailOnError("Failed to serve", err)
 }
 
-func masterWriteQueue(n *Node, fn func(map[int32]*proto.GraphNode) *proto.Job) error {
-	// Divide Graph in SubGraphs
-	numberOfJobs := len(n.State.Others)
-	if numberOfJobs >= len(n.State.Graph) {
-		numberOfJobs = len(n.State.Graph)
-	}
-	subGraphs := make([]map[int32]*proto.GraphNode, numberOfJobs)
-	i := 0
-	for id, node := range n.State.Graph {
-		if subGraphs[i] == nil {
-			subGraphs[i] = make(map[int32]*proto.GraphNode)
-		}
-		subGraphs[i][id] = node
-		i = (i + 1) % numberOfJobs
+// Send a sub-job for every partition; results are accepted only
+// if they are tagged with the current job, iteration and phase
+func masterWriteQueue(n *Node, phase Phase, fn func([]int32) *proto.Job) error {
+	if n.Job.Partitions == nil {
+		masterPartition(n)
 	}
+	// Switch phase before publishing, so that no result is considered stale
+	n.Job.startPhase(phase, len(n.Job.Partitions))
 	// Send subgraph to work queue
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	for _, subGraph := range subGraphs {
-		job := fn(subGraph)
-		data, err := protobuf.Marshal(job)
-		if err != nil {
-			return err
+	for i, nodes := range n.Job.Partitions {
+		job := fn(nodes)
+		job.Tag = &proto.Tag{
+			Job:       n.Job.Id,
+			Iteration: n.State.Iteration,
+			Phase:     int32(phase),
+			Sequence:  int32(i),
 		}
-		err = n.Queue.Channel.PublishWithContext(ctx,
-			"",
-			n.Queue.Work.Name, // routing key
-			false,             // mandatory
-			false,
-			amqp.Publishing{
-				DeliveryMode: amqp.Persistent,
-				ContentType:  "application/x-protobuf",
-				Body:         data,
-			})
-		if err != nil {
+		if err := n.Broker.PublishWork(job); err != nil {
 			return err
 		}
 	}
-	n.Jobs = numberOfJobs
 	return nil
 }
 
+// Divide Graph in SubGraphs (one for each worker) with the job strategy
+// The same partitions are used for every iteration of the job
+func masterPartition(n *Node) {
+	numberOfJobs := len(n.State.Others)
+	if numberOfJobs >= len(n.State.Graph) {
+		numberOfJobs = len(n.State.Graph)
+	}
+	partitioner := graph.NewPartitioner(n.State.Partitioning)
+	partitions := partitioner.Partition(n.State.Graph, numberOfJobs)
+	// Empty partitions would be sub-jobs without any work
+	nonEmpty := make([][]int32, 0, len(partitions))
+	for _, p := range partitions {
+		if len(p) > 0 {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	partitioning, _ := gonanoid.New()
+	n.Job.setPartitions(nonEmpty, partitioning)
+	stats := graph.Stats(n.State.Graph, nonEmpty)
+	cut := 0.0
+	if stats.Edges > 0 {
+		cut = 100 * float64(stats.EdgeCut) / float64(stats.Edges)
+	}
+	utils.NodeLog("master", "Partitioned graph (%s): %d partitions, edge cut %d/%d (%.2f%%), balance %.2f",
+		n.State.Partitioning, stats.Partitions, stats.EdgeCut, stats.Edges, cut, stats.Balance)
+}
+
 func masterReadQueue(n *Node, status chan bool) {
 	// Register consumer
-	msgs, err := n.Queue.Channel.Consume(
-		n.Queue.Result.Name, // queue
-		"",                  // consumer
-		false,               // auto-ack
-		false,               // exclusive
-		false,               // no-local
-		false,               // no-wait
-		nil,                 // args
-	)
-	utils.FailOnError("Could not register a consumer for %s queue", err, n.Queue.Result.Name)
-	utils.NodeLog("master", "Registered consumer for queue %s", n.Queue.Result.Name)
+	msgs, err := n.Broker.ConsumeResult()
+	_, queue := n.Broker.Queues()
+	utils.FailOnError("Could not register a consumer for %s queue", err, queue)
+	utils.NodeLog("master", "Registered consumer for queue %s", queue)
 	status <- true
 	for msg := range msgs {
-		var result proto.Result
-		err := protobuf.Unmarshal(msg.Body, &result)
-		if err != nil {
-			utils.FailOnNack(msg, err)
+		result := msg.Value
+		job := n.currentJob()
+		if job == nil || !job.accept(result.Tag) {
+			// Result from a previous step (or already received): removing it from the queue
+			tag := result.GetTag()
+			utils.NodeLog("master", "[WARN] Dropping stale result (job %s, iteration %d, phase %d, sub-job %d)",
+				tag.GetJob(), tag.GetIteration(), tag.GetPhase(), tag.GetSequence())
+			if err := msg.Ack(); err != nil {
+				utils.FailOnNack(msg.Nack, err)
+			}
 			continue
 		}
 		for id, v := range result.Values {
-			oldValue, ok := n.Data.Load(id)
+			oldValue, ok := job.Data.Load(id)
 			newValue := v
 			if ok {
 				newValue += oldValue.(float64)
 			}
-			n.Data.Store(id, newValue)
+			job.Data.Store(id, newValue)
 		}
 
-		// Ack
-		if err := msg.Ack(false); err != nil {
-			utils.FailOnNack(msg, err)
-			continue
+		// The values are added (and the sub-job marked as received) even if
+		// the ack fails: the redelivered message is dropped as already received
+		if err := msg.Ack(); err != nil {
+			utils.FailOnNack(msg.Nack, err)
 		}
-		// Correctly read message
-		n.Responses += 1
+		job.respond()
 	}
 }
@@ -0,0 +1,210 @@
+package node
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/pkg/graph"
+	"github.com/lioia/distributed-pagerank/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+func TestJobAccept(t *testing.T) {
+	tag := func(job string, iteration int32, phase Phase, sequence int32) *proto.Tag {
+		return &proto.Tag{Job: job, Iteration: iteration, Phase: int32(phase), Sequence: sequence}
+	}
+	tests := []struct {
+		name string
+		tag  *proto.Tag
+		ok   bool
+	}{
+		{"current step", tag("job", 3, Map, 0), true},
+		{"redelivered", tag("job", 3, Map, 0), false},
+		{"other sub-job", tag("job", 3, Map, 1), true},
+		{"no tag", nil, false},
+		{"other job", tag("old", 3, Map, 2), false},
+		{"previous iteration", tag("job", 2, Map, 2), false},
+		{"other phase", tag("job", 3, Reduce, 2), false},
+		{"unknown sub-job", tag("job", 3, Map, 3), false},
+		{"negative sub-job", tag("job", 3, Map, -1), false},
+	}
+	job := &Job{Id: "job", State: &proto.State{Job: "job", Iteration: 3}}
+	job.startPhase(Map, 3)
+	// The cases share the job: the first one marks sub-job 0 as received
+	for _, test := range tests {
+		if ok := job.accept(test.tag); ok != test.ok {
+			t.Errorf("%s: expected %v, got %v", test.name, test.ok, ok)
+		}
+	}
+}
+
+func TestMasterReadQueueDropsStaleResults(t *testing.T) {
+	broker := NewMemoryBroker(16)
+	job := &Job{Id: "job", State: &proto.State{Job: "job", Iteration: 1}}
+	job.startPhase(Reduce, 2)
+	n := &Node{Broker: broker, State: &proto.State{}, Job: job}
+	status := make(chan bool)
+	go masterReadQueue(n, status)
+	<-status
+	results := []*proto.Result{
+		{Tag: &proto.Tag{Job: "job", Iteration: 1, Phase: int32(Reduce), Sequence: 0}, Values: map[int32]float64{1: 0.5}},
+		// Redelivered result: its values are not added again
+		{Tag: &proto.Tag{Job: "job", Iteration: 1, Phase: int32(Reduce), Sequence: 0}, Values: map[int32]float64{1: 0.5}},
+		{Tag: &proto.Tag{Job: "job", Iteration: 0, Phase: int32(Reduce), Sequence: 1}, Values: map[int32]float64{1: 1}},
+		{Tag: &proto.Tag{Job: "other", Iteration: 1, Phase: int32(Reduce), Sequence: 1}, Values: map[int32]float64{1: 1}},
+		{Tag: &proto.Tag{Job: "job", Iteration: 1, Phase: int32(Reduce), Sequence: 1}, Values: map[int32]float64{1: 0.25, 2: 1}},
+	}
+	for _, result := range results {
+		if err := broker.PublishResult(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, job.completed)
+	expected := map[int32]float64{1: 0.75, 2: 1}
+	for id, value := range expected {
+		if v, ok := job.Data.Load(id); !ok || v.(float64) != value {
+			t.Errorf("node %d: expected %f, got %v", id, value, v)
+		}
+	}
+	if job.Responses != 2 {
+		t.Errorf("expected 2 responses, got %d", job.Responses)
+	}
+}
+
+func TestDistributedPageRank(t *testing.T) {
+	tests := []struct {
+		name         string
+		workers      int
+		partitioning proto.Partitioning
+		dangling     proto.Dangling
+	}{
+		{"one worker", 1, proto.Partitioning_PARTITIONING_HASH, proto.Dangling_DANGLING_UNIFORM},
+		{"range partitions", 3, proto.Partitioning_PARTITIONING_RANGE, proto.Dangling_DANGLING_UNIFORM},
+		{"in-degree partitions", 3, proto.Partitioning_PARTITIONING_IN_DEGREE, proto.Dangling_DANGLING_SELF_LOOP},
+		{"label propagation", 4, proto.Partitioning_PARTITIONING_LABEL_PROPAGATION, proto.Dangling_DANGLING_E},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := graph.ErdosRenyi(300, 0.01, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := cloneGraph(g)
+			graph.SingleNodePageRank(expected, 0.85, 1e-10, test.dangling, 0, func(float64, func()) bool { return true })
+
+			broker := NewMemoryBroker(64)
+			master := &Node{Id: "master", Role: Master, Broker: broker, State: &proto.State{
+				Others: startWorkers(t, broker, test.workers),
+			}}
+			status := make(chan bool)
+			go masterReadQueue(master, status)
+			<-status
+			job := master.Scheduler.Submit(&proto.State{
+				Graph:        g,
+				C:            0.85,
+				Threshold:    1e-10,
+				Partitioning: test.partitioning,
+				Dangling:     test.dangling,
+			})
+			waitFor(t, func() bool {
+				masterStep(master)
+				return job.progress().Status == proto.JobStatus_JOB_COMPLETED
+			})
+			page, err := job.ranks(&proto.RanksRequest{Order: proto.RanksOrder_ORDER_ID, Limit: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Nodes) != len(expected) {
+				t.Fatalf("expected %d nodes, got %d", len(expected), len(page.Nodes))
+			}
+			for _, node := range page.Nodes {
+				if math.Abs(node.Rank-expected[node.Id].Rank) > 1e-8 {
+					t.Errorf("node %d: expected rank %g, got %g", node.Id, expected[node.Id].Rank, node.Rank)
+				}
+			}
+		})
+	}
+}
+
+func TestMasterCancel(t *testing.T) {
+	g, err := graph.ErdosRenyi(300, 0.01, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := NewMemoryBroker(64)
+	master := &Node{Id: "master", Role: Master, Broker: broker, State: &proto.State{
+		Others: startWorkers(t, broker, 2),
+	}}
+	status := make(chan bool)
+	go masterReadQueue(master, status)
+	<-status
+	job := master.Scheduler.Submit(&proto.State{Graph: g, C: 0.85, Threshold: 1e-10})
+	// Cancelled while the results of the first Map phase are received
+	masterStep(master)
+	masterStep(master)
+	if _, err := master.Scheduler.Cancel(job.Id); err != nil {
+		t.Fatal(err)
+	}
+	masterStep(master)
+	if status := job.progress().Status; status != proto.JobStatus_JOB_CANCELLED {
+		t.Fatalf("expected a cancelled job, got %s", statusName(status))
+	}
+	if master.currentJob() != nil {
+		t.Errorf("master is still computing job %s", job.Id)
+	}
+	// The master computes the next job
+	next := master.Scheduler.Submit(&proto.State{Graph: cloneGraph(g), C: 0.85, Threshold: 1e-10})
+	waitFor(t, func() bool {
+		masterStep(master)
+		return next.progress().Status == proto.JobStatus_JOB_COMPLETED
+	})
+}
+
+// Start workers reading from the broker, with their node server
+// (used by the master to send the job parameters)
+func startWorkers(t *testing.T, broker Broker, workers int) map[string]string {
+	others := make(map[string]string)
+	for i := 0; i < workers; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker := &Node{
+			Id:          fmt.Sprintf("worker-%d", i),
+			Role:        Worker,
+			Connection:  lis.Addr().String(),
+			Broker:      broker,
+			State:       &proto.State{},
+			QueueReader: make(chan bool),
+		}
+		worker.StartServer(lis)
+		go readQueue(worker)
+		t.Cleanup(func() { close(worker.QueueReader) })
+		others[worker.Id] = worker.Connection
+	}
+	return others
+}
+
+func cloneGraph(g map[int32]*proto.GraphNode) map[int32]*proto.GraphNode {
+	clone := make(map[int32]*proto.GraphNode, len(g))
+	for id, u := range g {
+		clone[id] = protobuf.Clone(u).(*proto.GraphNode)
+	}
+	return clone
+}
+
+// Wait until done returns true (failing after 10 seconds)
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,134 @@
+package node
+
+import (
+	"sync"
+
+	"github.com/lioia/distributed-pagerank/proto"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// In-process implementation of Broker (every node has to run in the same process)
+type MemoryBroker struct {
+	mu        sync.Mutex
+	work      chan *proto.Job
+	result    chan *proto.Result
+	consumers map[string]chan bool // Cancel channel for every consumer
+}
+
+// Create a broker whose queues can hold up to size messages
+func NewMemoryBroker(size int) *MemoryBroker {
+	return &MemoryBroker{
+		work:      make(chan *proto.Job, size),
+		result:    make(chan *proto.Result, size),
+		consumers: make(map[string]chan bool),
+	}
+}
+
+func (b *MemoryBroker) PublishWork(job *proto.Job) error {
+	// Messages are copied, as they would be serialized by a real broker
+	b.work <- protobuf.Clone(job).(*proto.Job)
+	return nil
+}
+
+func (b *MemoryBroker) ConsumeWork(consumer string) (<-chan Delivery[*proto.Job], error) {
+	return memoryDeliveries(b.work, b.register(consumer)), nil
+}
+
+func (b *MemoryBroker) PublishResult(result *proto.Result) error {
+	b.result <- protobuf.Clone(result).(*proto.Result)
+	return nil
+}
+
+func (b *MemoryBroker) ConsumeResult() (<-chan Delivery[*proto.Result], error) {
+	return memoryDeliveries(b.result, b.register("")), nil
+}
+
+func (b *MemoryBroker) Cancel(consumer string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cancel, ok := b.consumers[consumer]; ok {
+		close(cancel)
+		delete(b.consumers, consumer)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Purge() error {
+	memoryPurge(b.work)
+	memoryPurge(b.result)
+	return nil
+}
+
+func (b *MemoryBroker) Queues() (string, string) {
+	return "memory-work", "memory-result"
+}
+
+func (b *MemoryBroker) register(consumer string) chan bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cancel := make(chan bool)
+	if consumer != "" {
+		b.consumers[consumer] = cancel
+	}
+	return cancel
+}
+
+// Deliver messages from queue one at a time (as with a prefetch count of 1):
+// the next message is received only after the previous one was acknowledged
+func memoryDeliveries[T protobuf.Message](queue chan T, cancel chan bool) <-chan Delivery[T] {
+	deliveries := make(chan Delivery[T])
+	go func() {
+		defer close(deliveries)
+		for {
+			var msg T
+			select {
+			case <-cancel:
+				return
+			case msg = <-queue:
+			}
+			done := make(chan bool, 1)
+			delivery := Delivery[T]{
+				Value: msg,
+				Ack: func() error {
+					done <- true
+					return nil
+				},
+				Nack: func() error {
+					// Re-add message to the queue
+					go func() { queue <- msg }()
+					done <- true
+					return nil
+				},
+			}
+			select {
+			case <-cancel:
+				go func() { queue <- msg }()
+				return
+			case deliveries <- delivery:
+			}
+			select {
+			case <-cancel:
+				select {
+				case <-done:
+				default:
+					// Delivered but not acknowledged: the consumer stopped,
+					// so the message is re-added to the queue
+					go func() { queue <- msg }()
+				}
+				return
+			case <-done:
+			}
+		}
+	}()
+	return deliveries
+}
+
+func memoryPurge[T any](queue chan T) {
+	for {
+		select {
+		case <-queue:
+		default:
+			return
+		}
+	}
+}
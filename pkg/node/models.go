@@ -1,11 +1,15 @@
 package node
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/lioia/distributed-pagerank/pkg/graph"
 	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // Phase can be treated as an enum
@@ -34,21 +38,491 @@ type Node struct {
 	Role          Role         // What this node has to do
 	Connection    string       // This node connection information
 	APIConnection string       // API Connection string
-	Queue         Queue        // Queue information
+	Broker        Broker       // Job and result queues
 	Master        string       // Master node (set if this node is a worker)
 	Candidacy     string       // Id of new candidacy (0: no candidate)
 	QueueReader   chan bool    // Cancel channel for worker goroutine
-	Phase         Phase        // Master state: current computation (master as a FSM)
-	Jobs          int          // Master state: number of jobs in the work queue
-	Responses     int          // Master state: number of read result messages
-	Data          sync.Map     // Master state: data collected from result queue (std map is no thread safe)
+	Scheduler     Scheduler    // Master state: jobs submitted by the clients
+	Job           *Job         // Master state: job being computed (nil if idle)
+	Checkpoint    int32        // Master state: iterations between checkpoints of the job (0: disabled)
+	Partitions    Partitions   // Worker state: graph partitions kept across iterations
 }
 
-type Queue struct {
-	Conn    *amqp.Connection
-	Channel *amqp.Channel
-	Work    *amqp.Queue
-	Result  *amqp.Queue
+// Graph partitions kept by a worker (only for the job being computed)
+type Partitions struct {
+	Job   string                // Job the partitions belong to
+	Nodes map[string]*graph.CSR // Partitioning ID and sequence -> adjacency
+}
+
+// A PageRank computation requested by a client
+type Job struct {
+	mu        sync.Mutex     // Thread Safety for result bookkeeping
+	Id        string         // Job ID (returned to the client)
+	State     *proto.State   // Job state (graph, PageRank parameters, iteration)
+	Phase     Phase          // Current computation (job as a FSM)
+	SubJobs   int            // Number of sub-jobs in the work queue
+	Responses int            // Number of read result messages
+	Received  map[int32]bool // Sub-jobs (sequence number) whose result was accepted
+	Data      sync.Map       // Data collected from result queue (std map is no thread safe)
+
+	Partitions   [][]int32  // Node IDs of every sub-graph (one sub-job each)
+	Partitioning string     // Partitioning ID (workers keep the partitions until it changes)
+	Distributed  bool       // Graph and partitions were already sent to the workers
+	Graph        *graph.CSR // Whole graph (out-degrees), used to redistribute the dangling nodes rank
+
+	Status    proto.JobStatus                   // Pending, running, completed or cancelled
+	Nodes     int                               // Number of nodes of the graph
+	Submitted time.Time                         // Submission time
+	Delta     float64                           // Convergence delta of the last iteration
+	Result    *proto.Ranks                      // Final ranks (nil until the job is completed; only the status once saved)
+	results   *results                          // Final ranks sorted for GetRanks (nil once saved)
+	cancel    bool                              // Cancel requested (the master stops the job)
+	watchers  map[chan *proto.JobEvent]struct{} // Clients watching the job progress
+	store     *Store                            // Store the final ranks are loaded from (job saved in the store)
+}
+
+// Final ranks of a job, sorted for the pages of GetRanks
+type results struct {
+	ranks  *proto.Ranks
+	byRank []int32 // Node IDs sorted by rank
+	byID   []int32 // Node IDs sorted by ID
+}
+
+func newResults(ranks *proto.Ranks) *results {
+	return &results{
+		ranks:  ranks,
+		byRank: graph.SortByRank(ranks.Ranks),
+		byID:   graph.SortByID(ranks.Ranks),
+	}
+}
+
+// Prepare the job to receive the results of a new step
+func (j *Job) startPhase(phase Phase, subJobs int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Phase = phase
+	j.SubJobs = subJobs
+	j.Responses = 0
+	j.Received = make(map[int32]bool)
+}
+
+// Switch to the next step of the job (the results of this one are all processed)
+func (j *Job) setPhase(phase Phase) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Phase = phase
+}
+
+// Store the convergence delta of the last iteration
+func (j *Job) setDelta(delta float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Delta = delta
+}
+
+// Start the next iteration of the job (delta is the one of the last iteration)
+func (j *Job) iterate(delta float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Phase = Wait
+	j.Delta = delta
+	j.State.Iteration += 1
+}
+
+// Check that a result belongs to the current step of the job
+// and that its sub-job was not already received (marking it as received)
+func (j *Job) accept(tag *proto.Tag) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if tag == nil || tag.Job != j.Id || tag.Iteration != j.State.Iteration {
+		return false
+	}
+	if Phase(tag.Phase) != j.Phase || int(tag.Sequence) >= j.SubJobs || tag.Sequence < 0 {
+		return false
+	}
+	if j.Received[tag.Sequence] {
+		// Sub-job result was already added (redelivered message)
+		return false
+	}
+	j.Received[tag.Sequence] = true
+	return true
+}
+
+// Remove the data collected in the last step; the map is not replaced,
+// since the result reader may be using it
+func (j *Job) clearData() {
+	j.Data.Range(func(key, _ any) bool {
+		j.Data.Delete(key)
+		return true
+	})
+}
+
+// Mark an accepted result as completely processed
+func (j *Job) respond() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Responses += 1
+}
+
+// Whether every sub-job of the current step has been processed
+func (j *Job) completed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.SubJobs == j.Responses
+}
+
+// Readable job status (e.g. completed)
+func statusName(status proto.JobStatus) string {
+	return strings.ToLower(strings.TrimPrefix(status.String(), "JOB_"))
+}
+
+// Whether the job will not be computed anymore
+func finished(status proto.JobStatus) bool {
+	return status == proto.JobStatus_JOB_COMPLETED || status == proto.JobStatus_JOB_CANCELLED
+}
+
+// Register a watcher of the job progress; the channel is closed when the
+// job is completed (the returned function removes the watcher)
+func (j *Job) watch() (chan *proto.JobEvent, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	events := make(chan *proto.JobEvent, 16)
+	if finished(j.Status) {
+		close(events)
+		return events, func() {}
+	}
+	if j.watchers == nil {
+		j.watchers = make(map[chan *proto.JobEvent]struct{})
+	}
+	j.watchers[events] = struct{}{}
+	return events, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.watchers, events)
+	}
+}
+
+// Current progress of the job
+func (j *Job) progress() *proto.JobEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.event()
+}
+
+// Progress event of the job (the caller holds j.mu)
+func (j *Job) event() *proto.JobEvent {
+	event := &proto.JobEvent{
+		Job:       j.Id,
+		Iteration: j.State.Iteration,
+		Delta:     j.Delta,
+		Status:    j.Status,
+	}
+	if j.Result != nil {
+		// The ranks are fetched with GetRanks
+		event.Ranks = &proto.Ranks{
+			Master: j.Result.Master,
+			Status: j.Result.Status,
+			Job:    j.Result.Job,
+			Seed:   j.Result.Seed,
+		}
+	}
+	return event
+}
+
+// Summary of the job
+func (j *Job) info() *proto.JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := &proto.JobInfo{
+		Job:       j.Id,
+		Status:    j.Status,
+		Iteration: j.State.Iteration,
+		Delta:     j.Delta,
+		Nodes:     int32(j.Nodes),
+		C:         j.State.C,
+		Threshold: j.State.Threshold,
+		Submitted: j.Submitted.UnixMilli(),
+	}
+	if j.Status == proto.JobStatus_JOB_RUNNING {
+		info.Phase = int32(j.Phase)
+	}
+	return info
+}
+
+// Whether a client requested to stop the job
+func (j *Job) cancelled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cancel
+}
+
+// Send the job progress to the watchers
+// (slow watchers skip the event: they will receive the next one)
+func (j *Job) notify() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	event := j.event()
+	for events := range j.watchers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Store the final status (and ranks), send the final event to the watchers
+// and close them
+func (j *Job) finish(status proto.JobStatus, ranks *proto.Ranks) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = status
+	j.Result = ranks
+	if ranks != nil {
+		j.results = newResults(ranks)
+	}
+	event := j.event()
+	for events := range j.watchers {
+		// The final event is never skipped: slow watchers lose their oldest event
+		for sent := false; !sent; {
+			select {
+			case events <- event:
+				sent = true
+			default:
+				select {
+				case <-events:
+				default:
+				}
+			}
+		}
+		close(events)
+	}
+	j.watchers = nil
+}
+
+// The final ranks were saved in the store: they are loaded from it on request
+// (only the status is kept in memory)
+func (j *Job) stored(store *Store) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Result = &proto.Ranks{
+		Master: j.Result.Master,
+		Status: j.Result.Status,
+		Job:    j.Result.Job,
+		Seed:   j.Result.Seed,
+	}
+	j.results = nil
+	j.store = store
+}
+
+// Limits of a GetRanks or GetEdges response (gRPC messages are at most 4MB)
+const (
+	maxPageNodes = 10000
+	maxPageEdges = 100000
+)
+
+// Final ranks of the completed job (loaded from the store if they were saved)
+func (j *Job) final() (*results, error) {
+	j.mu.Lock()
+	results, store, status := j.results, j.store, j.Status
+	j.mu.Unlock()
+	if results != nil {
+		return results, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("Job %s is %s", j.Id, statusName(status))
+	}
+	results, err := store.results(j.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load ranks of job %s: %v", j.Id, err)
+	}
+	return results, nil
+}
+
+// Final ranks of the job as requested (nil if the job is not completed)
+func (j *Job) ranks(in *proto.RanksRequest) (*proto.RanksPage, error) {
+	final, err := j.final()
+	if err != nil {
+		return nil, err
+	}
+	result := final.ranks
+	var ids []int32
+	if len(in.Ids) > 0 {
+		if len(in.Ids) > maxPageNodes {
+			return nil, fmt.Errorf("Too many nodes requested (at most %d)", maxPageNodes)
+		}
+		for _, id := range in.Ids {
+			if _, ok := result.Ranks[id]; !ok {
+				return nil, fmt.Errorf("Unknown node %d", id)
+			}
+		}
+		ids = in.Ids
+	} else {
+		ids = final.byRank
+		offset, limit := int(in.Offset), int(in.Limit)
+		if in.Top > 0 {
+			offset, limit = 0, int(in.Top)
+		} else if in.Order == proto.RanksOrder_ORDER_ID {
+			ids = final.byID
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("Invalid offset %d", offset)
+		}
+		if limit <= 0 {
+			limit = 100
+		}
+		if limit > maxPageNodes {
+			limit = maxPageNodes
+		}
+		if offset > len(ids) {
+			offset = len(ids)
+		}
+		if offset+limit > len(ids) {
+			limit = len(ids) - offset
+		}
+		ids = ids[offset : offset+limit]
+	}
+	page := &proto.RanksPage{
+		Job:   j.Id,
+		Total: int32(len(result.Ranks)),
+		Nodes: make([]*proto.NodeRank, 0, len(ids)),
+	}
+	for _, id := range ids {
+		page.Nodes = append(page.Nodes, &proto.NodeRank{
+			Id:    id,
+			Rank:  result.Ranks[id],
+			Label: graph.Label(result, id),
+		})
+		if in.Edges && !page.Truncated {
+			edges := graph.OutEdges(result.Edges, id)
+			if left := 2*maxPageEdges - len(page.Edges); len(edges) > left {
+				edges = edges[:left]
+				page.Truncated = true
+			}
+			page.Edges = append(page.Edges, edges...)
+		}
+	}
+	return page, nil
+}
+
+// Edges of the graph of the job as requested (nil if the job is not completed)
+func (j *Job) edges(in *proto.EdgesRequest) (*proto.EdgesPage, error) {
+	final, err := j.final()
+	if err != nil {
+		return nil, err
+	}
+	result := final.ranks
+	total := len(result.Edges) / 2
+	offset, limit := int(in.Offset), int(in.Limit)
+	if offset < 0 {
+		return nil, fmt.Errorf("Invalid offset %d", offset)
+	}
+	if limit <= 0 {
+		limit = 10000
+	}
+	if limit > maxPageEdges {
+		limit = maxPageEdges
+	}
+	if offset > total {
+		offset = total
+	}
+	if offset+limit > total {
+		limit = total - offset
+	}
+	return &proto.EdgesPage{
+		Job:   j.Id,
+		Total: int32(total),
+		Edges: result.Edges[2*offset : 2*(offset+limit)],
+	}, nil
+}
+
+// Free the graph of a job that is not computed anymore
+// (only the parameters and the results are kept)
+func (j *Job) release() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.State = &proto.State{
+		C:            j.State.C,
+		Threshold:    j.State.Threshold,
+		Iteration:    j.State.Iteration,
+		Job:          j.State.Job,
+		Partitioning: j.State.Partitioning,
+		Dangling:     j.State.Dangling,
+		Seed:         j.State.Seed,
+	}
+	j.Graph = nil
+	j.Partitions = nil
+}
+
+// Set the partitions of the job (the same ones are used for every iteration)
+func (j *Job) setPartitions(partitions [][]int32, partitioning string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Partitions = partitions
+	j.Partitioning = partitioning
+}
+
+// Adjacency of a partition of the job (requested by a worker that lost it)
+func (j *Job) partition(partitioning string, sequence int32) (map[int32]*proto.Map, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Partitioning != partitioning {
+		return nil, fmt.Errorf("Unknown partitioning %s", partitioning)
+	}
+	if sequence < 0 || int(sequence) >= len(j.Partitions) {
+		return nil, fmt.Errorf("Unknown partition %d", sequence)
+	}
+	nodes := make(map[int32]*proto.Map, len(j.Partitions[sequence]))
+	for _, id := range j.Partitions[sequence] {
+		nodes[id] = &proto.Map{InLinks: workerLinks(j.State.Graph[id].InLinks)}
+	}
+	return nodes, nil
+}
+
+// Copy of the in-links of a node sent to a worker: the master updates the
+// ranks of the in-links while the copy is sent (workers receive the ranks
+// with every Map job)
+func workerLinks(inLinks map[int32]*proto.GraphNodeInfo) map[int32]*proto.GraphNodeInfo {
+	links := make(map[int32]*proto.GraphNodeInfo, len(inLinks))
+	for j, v := range inLinks {
+		links[j] = &proto.GraphNodeInfo{Outlinks: v.Outlinks, Probability: v.Probability}
+	}
+	return links
+}
+
+// State shared with the workers: parameters of the job being computed,
+// without the graph (workers only keep their partitions)
+func sharedState(state *proto.State) *proto.State {
+	return &proto.State{
+		C:            state.C,
+		Threshold:    state.Threshold,
+		Iteration:    state.Iteration,
+		Others:       state.Others,
+		Job:          state.Job,
+		Partitioning: state.Partitioning,
+		Dangling:     state.Dangling,
+		Seed:         state.Seed,
+	}
+}
+
+// Job being computed by the master (nil if idle)
+func (n *Node) currentJob() *Job {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.Job
+}
+
+// Connection information of the other nodes in the network (sorted)
+func (n *Node) workers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	workers := make([]string, 0, len(n.State.Others))
+	for _, v := range n.State.Others {
+		if v != n.Connection {
+			workers = append(workers, v)
+		}
+	}
+	sort.Strings(workers)
+	return workers
 }
 
 func RoleToString(role Role) string {
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+func TestJobWatch(t *testing.T) {
+	job := &Job{Id: "job", State: &proto.State{Job: "job"}, Status: proto.JobStatus_JOB_RUNNING}
+	events, stop := job.watch()
+	defer stop()
+	// Slow watcher: its buffer is full when the job is completed
+	for i := 0; i < cap(events)+5; i++ {
+		job.iterate(0.5)
+		job.notify()
+	}
+	job.finish(proto.JobStatus_JOB_COMPLETED, &proto.Ranks{Job: "job", Status: "Converged"})
+	var last *proto.JobEvent
+	terminal := 0
+	for event := range events {
+		if finished(event.Status) {
+			terminal += 1
+		}
+		last = event
+	}
+	if terminal != 1 {
+		t.Fatalf("expected one final event, got %d", terminal)
+	}
+	if last.Status != proto.JobStatus_JOB_COMPLETED || last.Ranks.GetStatus() != "Converged" {
+		t.Errorf("unexpected final event %v", last)
+	}
+	// Watching a completed job: no event (the current progress is sent by WatchJob)
+	events, _ = job.watch()
+	if _, ok := <-events; ok {
+		t.Errorf("expected a closed channel")
+	}
+}
+
+// Completed job with n nodes (rank decreasing with the ID) and
+// degree out-links for every node
+func completedJob(n, degree int) *Job {
+	ranks := &proto.Ranks{Job: "job", Ranks: make(map[int32]float64, n)}
+	for id := 0; id < n; id++ {
+		ranks.Ranks[int32(id)] = float64(n - id)
+		for to := 0; to < degree; to++ {
+			ranks.Edges = append(ranks.Edges, int32(id), int32(to))
+		}
+	}
+	job := &Job{Id: "job", State: &proto.State{Job: "job"}}
+	job.finish(proto.JobStatus_JOB_COMPLETED, ranks)
+	return job
+}
+
+func TestRanksPages(t *testing.T) {
+	job := completedJob(maxPageNodes+500, 12)
+	tests := []struct {
+		name      string
+		request   *proto.RanksRequest
+		first     int32 // ID of the first node of the page
+		nodes     int
+		edges     int  // Number of edges of the page (pairs)
+		truncated bool // Edges truncated at maxPageEdges
+		err       string
+	}{
+		{name: "default limit", request: &proto.RanksRequest{}, first: 0, nodes: 100},
+		{name: "limit capped", request: &proto.RanksRequest{Limit: 2 * maxPageNodes}, first: 0, nodes: maxPageNodes},
+		{name: "top capped", request: &proto.RanksRequest{Top: 2 * maxPageNodes}, first: 0, nodes: maxPageNodes},
+		{name: "last page", request: &proto.RanksRequest{Offset: maxPageNodes, Limit: 1000}, first: maxPageNodes, nodes: 500},
+		{name: "after the last page", request: &proto.RanksRequest{Offset: 2 * maxPageNodes}, nodes: 0},
+		{name: "by ID", request: &proto.RanksRequest{Order: proto.RanksOrder_ORDER_ID, Offset: 10, Limit: 5}, first: 10, nodes: 5},
+		{name: "with edges", request: &proto.RanksRequest{Limit: 10, Edges: true}, first: 0, nodes: 10, edges: 120},
+		{
+			name:      "edges capped",
+			request:   &proto.RanksRequest{Limit: maxPageNodes, Edges: true},
+			first:     0,
+			nodes:     maxPageNodes,
+			edges:     maxPageEdges,
+			truncated: true,
+		},
+		{name: "specific nodes", request: &proto.RanksRequest{Ids: []int32{42, 7}}, first: 42, nodes: 2},
+		{name: "too many nodes", request: &proto.RanksRequest{Ids: make([]int32, maxPageNodes+1)}, err: "Too many nodes"},
+		{name: "unknown node", request: &proto.RanksRequest{Ids: []int32{-1}}, err: "Unknown node -1"},
+		{name: "negative offset", request: &proto.RanksRequest{Offset: -1}, err: "Invalid offset"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := job.ranks(test.request)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if page.Total != maxPageNodes+500 {
+				t.Errorf("expected %d nodes in total, got %d", maxPageNodes+500, page.Total)
+			}
+			if len(page.Nodes) != test.nodes {
+				t.Fatalf("expected %d nodes, got %d", test.nodes, len(page.Nodes))
+			}
+			if test.nodes > 0 && page.Nodes[0].Id != test.first {
+				t.Errorf("expected node %d first, got %d", test.first, page.Nodes[0].Id)
+			}
+			if len(page.Edges) != 2*test.edges || page.Truncated != test.truncated {
+				t.Errorf("expected %d edges (truncated %v), got %d (truncated %v)",
+					test.edges, test.truncated, len(page.Edges)/2, page.Truncated)
+			}
+		})
+	}
+}
+
+func TestEdgesPages(t *testing.T) {
+	// 3 * maxPageEdges edges
+	job := completedJob(3*maxPageEdges/100, 100)
+	total := 3 * maxPageEdges
+	tests := []struct {
+		name    string
+		request *proto.EdgesRequest
+		edges   int
+		first   int32 // Source of the first edge of the page
+		err     string
+	}{
+		{name: "default limit", request: &proto.EdgesRequest{}, edges: 10000},
+		{name: "limit capped", request: &proto.EdgesRequest{Limit: 2 * maxPageEdges}, edges: maxPageEdges},
+		{name: "offset", request: &proto.EdgesRequest{Offset: 250, Limit: 100}, edges: 100, first: 2},
+		{name: "last page", request: &proto.EdgesRequest{Offset: int32(total) - 50, Limit: 100}, edges: 50, first: int32(total/100) - 1},
+		{name: "after the last page", request: &proto.EdgesRequest{Offset: int32(total) + 1}, edges: 0},
+		{name: "negative offset", request: &proto.EdgesRequest{Offset: -1}, err: "Invalid offset"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := job.edges(test.request)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if int(page.Total) != total {
+				t.Errorf("expected %d edges in total, got %d", total, page.Total)
+			}
+			if len(page.Edges) != 2*test.edges {
+				t.Fatalf("expected %d edges, got %d", test.edges, len(page.Edges)/2)
+			}
+			if test.edges > 0 && page.Edges[0] != test.first {
+				t.Errorf("expected first edge from %d, got %d", test.first, page.Edges[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/pkg/utils"
+	"github.com/lioia/distributed-pagerank/proto"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Finished jobs kept by the scheduler, besides the stored ones
+// (GetJob and GetRanks of older jobs fail)
+const keptJobs = 100
+
+// Jobs submitted to the master; they are computed one after another
+// in submission order
+type Scheduler struct {
+	mu         sync.Mutex
+	jobs       map[string]*Job // Every submitted job (id -> job)
+	pending    []*Job          // Jobs waiting to be computed (FIFO)
+	store      *Store          // Completed jobs saved on disk (nil: not saved)
+	checkpoint bool            // Submitted jobs are checkpointed (they are not lost on restart)
+}
+
+// Save the completed jobs in dir; the jobs already stored are restored
+// (unreadable stored jobs are skipped)
+func (s *Scheduler) Open(dir string, checkpoint bool) error {
+	store, err := OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	jobs, errs := store.List()
+	for _, err := range errs {
+		utils.NodeLog("master", "[WARN] Could not restore job: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	s.checkpoint = checkpoint
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+	}
+	for _, job := range jobs {
+		if s.jobs[job.Id] == nil {
+			s.jobs[job.Id] = job
+		}
+	}
+	return nil
+}
+
+// Store of the completed jobs and of the checkpoints (nil if not opened)
+func (s *Scheduler) Store() *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store
+}
+
+// Save the results of a completed job (if the scheduler has a store);
+// once saved, they are not kept in memory
+func (s *Scheduler) Save(job *Job) error {
+	s.mu.Lock()
+	store := s.store
+	s.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	if err := store.Save(job); err != nil {
+		return err
+	}
+	job.stored(store)
+	return nil
+}
+
+// Queue a new job for the provided state; the job ID is set in the state
+// The job is checkpointed before it is queued (if enabled)
+func (s *Scheduler) Submit(state *proto.State) *Job {
+	s.mu.Lock()
+	id, _ := gonanoid.New()
+	for s.jobs[id] != nil {
+		// ID already assigned to job; generating new one
+		id, _ = gonanoid.New()
+	}
+	state.Job = id
+	job := s.add(state)
+	store, checkpoint := s.store, s.checkpoint
+	s.mu.Unlock()
+	if store != nil && checkpoint {
+		if err := store.StartCheckpoint(job); err != nil {
+			utils.NodeLog("master", "[WARN] Could not checkpoint job %s: %v", id, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.mu.Lock()
+	status := job.Status
+	job.mu.Unlock()
+	if status != proto.JobStatus_JOB_PENDING {
+		// Cancelled while it was checkpointed
+		s.removeCheckpoint(id)
+		return job
+	}
+	s.pending = append(s.pending, job)
+	return job
+}
+
+// Queue a job that was checkpointed before a restart (it keeps its position
+// in submission order); nil if the job was already completed
+func (s *Scheduler) Requeue(checkpoint *Checkpoint) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job := s.jobs[checkpoint.State.Job]; job != nil {
+		job.mu.Lock()
+		status := job.Status
+		job.mu.Unlock()
+		if finished(status) {
+			// Stopped after saving the job, before removing its checkpoint
+			s.removeCheckpoint(job.Id)
+		}
+		return nil
+	}
+	job := s.add(checkpoint.State)
+	job.Submitted = checkpoint.Submitted
+	job.Delta = checkpoint.Delta
+	i := sort.Search(len(s.pending), func(i int) bool {
+		return s.pending[i].Submitted.After(job.Submitted)
+	})
+	s.pending = append(s.pending[:i], append([]*Job{job}, s.pending[i:]...)...)
+	return job
+}
+
+// Remove the oldest pending job from the queue (nil if there is none)
+func (s *Scheduler) Next() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	job := s.pending[0]
+	s.pending = s.pending[1:]
+	job.mu.Lock()
+	job.Status = proto.JobStatus_JOB_RUNNING
+	job.mu.Unlock()
+	return job
+}
+
+// Stop a job: pending jobs are removed from the queue, running jobs are
+// stopped by the master
+func (s *Scheduler) Cancel(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[id]
+	if job == nil {
+		return nil, fmt.Errorf("Unknown job %s", id)
+	}
+	job.mu.Lock()
+	status := job.Status
+	job.mu.Unlock()
+	switch status {
+	case proto.JobStatus_JOB_PENDING:
+		for i, v := range s.pending {
+			if v == job {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				break
+			}
+		}
+		job.finish(proto.JobStatus_JOB_CANCELLED, nil)
+		job.release()
+		// Pending jobs are checkpointed when they are submitted
+		s.removeCheckpoint(id)
+	case proto.JobStatus_JOB_RUNNING:
+		job.mu.Lock()
+		job.cancel = true
+		job.mu.Unlock()
+	default:
+		return nil, fmt.Errorf("Job %s is already %s", id, statusName(status))
+	}
+	return job, nil
+}
+
+// Every submitted job (submission order)
+func (s *Scheduler) List() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Submitted.Before(jobs[j].Submitted)
+	})
+	return jobs
+}
+
+// Get a submitted job by its ID (nil if it does not exist)
+func (s *Scheduler) Get(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// Remove the checkpoint of a job that will not be computed
+func (s *Scheduler) removeCheckpoint(id string) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.RemoveCheckpoint(id); err != nil {
+		utils.NodeLog("master", "[WARN] Could not remove checkpoint of job %s: %v", id, err)
+	}
+}
+
+func (s *Scheduler) add(state *proto.State) *Job {
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+	}
+	s.prune()
+	job := &Job{
+		Id:        state.Job,
+		State:     state,
+		Phase:     Wait,
+		Nodes:     len(state.Graph),
+		Submitted: time.Now(),
+	}
+	s.jobs[job.Id] = job
+	return job
+}
+
+// Forget the oldest finished jobs, keeping the last keptJobs ones
+// (stored jobs are always kept: their ranks are not in memory)
+func (s *Scheduler) prune() {
+	var done []*Job
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		if finished(job.Status) && job.store == nil {
+			done = append(done, job)
+		}
+		job.mu.Unlock()
+	}
+	if len(done) <= keptJobs {
+		return
+	}
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].Submitted.Before(done[j].Submitted)
+	})
+	for _, job := range done[:len(done)-keptJobs] {
+		delete(s.jobs, job.Id)
+	}
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// State of a job with a small graph (0 -> 1 -> 2 -> 0)
+func testState() *proto.State {
+	g := make(map[int32]*proto.GraphNode)
+	for id := int32(0); id < 3; id++ {
+		g[id] = &proto.GraphNode{Rank: 1.0 / 3, E: 1.0 / 3, InLinks: map[int32]*proto.GraphNodeInfo{
+			(id + 2) % 3: {Outlinks: 1, Rank: 1.0 / 3, Probability: 1},
+		}}
+	}
+	return &proto.State{Graph: g, C: 0.85, Threshold: 1e-6}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	var s Scheduler
+	var submitted []*Job
+	for i := 0; i < 3; i++ {
+		job := s.Submit(testState())
+		if job.Id == "" || job.State.Job != job.Id {
+			t.Fatalf("job ID not set in the state")
+		}
+		if status := job.progress().Status; status != proto.JobStatus_JOB_PENDING {
+			t.Fatalf("expected a pending job, got %s", statusName(status))
+		}
+		submitted = append(submitted, job)
+	}
+	for i, job := range s.List() {
+		if job != submitted[i] {
+			t.Errorf("job %d: expected %s, got %s", i, submitted[i].Id, job.Id)
+		}
+	}
+	for _, expected := range submitted {
+		job := s.Next()
+		if job != expected {
+			t.Fatalf("expected job %s, got %v", expected.Id, job)
+		}
+		if status := job.progress().Status; status != proto.JobStatus_JOB_RUNNING {
+			t.Errorf("expected a running job, got %s", statusName(status))
+		}
+		if s.Get(job.Id) != job {
+			t.Errorf("job %s not found", job.Id)
+		}
+	}
+	if job := s.Next(); job != nil {
+		t.Errorf("expected no pending job, got %s", job.Id)
+	}
+}
+
+func TestSchedulerPrune(t *testing.T) {
+	var s Scheduler
+	var jobs []*Job
+	for i := 0; i < keptJobs+5; i++ {
+		job := s.Submit(testState())
+		s.Next()
+		job.finish(proto.JobStatus_JOB_COMPLETED, &proto.Ranks{Job: job.Id})
+		jobs = append(jobs, job)
+	}
+	// Pruned when the next job is submitted
+	s.Submit(testState())
+	for i, job := range jobs {
+		if pruned := s.Get(job.Id) == nil; pruned != (i < 5) {
+			t.Errorf("job %d: expected pruned %v, got %v", i, i < 5, pruned)
+		}
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	var s Scheduler
+	running := s.Submit(testState())
+	pending := s.Submit(testState())
+	last := s.Submit(testState())
+	s.Next()
+	tests := []struct {
+		name   string
+		id     string
+		status proto.JobStatus // Status after the cancellation
+		err    bool
+	}{
+		{"pending", pending.Id, proto.JobStatus_JOB_CANCELLED, false},
+		{"running", running.Id, proto.JobStatus_JOB_RUNNING, false},
+		{"already cancelled", pending.Id, proto.JobStatus_JOB_CANCELLED, true},
+		{"unknown", "unknown", 0, true},
+	}
+	for _, test := range tests {
+		job, err := s.Cancel(test.id)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if err == nil && job.progress().Status != test.status {
+			t.Errorf("%s: expected %s, got %s", test.name, statusName(test.status), statusName(job.progress().Status))
+		}
+	}
+	// Running jobs are stopped by the master
+	if !running.cancelled() {
+		t.Errorf("running job not marked as cancelled")
+	}
+	// Cancelled pending jobs are removed from the queue and free their graph
+	if pending.State.Graph != nil {
+		t.Errorf("graph of the cancelled job not released")
+	}
+	if job := s.Next(); job != last {
+		t.Errorf("expected job %s, got %v", last.Id, job)
+	}
+}
@@ -2,10 +2,13 @@ package node
 
 import (
 	"context"
+	"fmt"
+	"net"
 
 	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
 	gonanoid "github.com/matoous/go-nanoid/v2"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -15,6 +18,35 @@ type NodeServerImpl struct {
 	proto.UnimplementedNodeServer
 }
 
+// Serve internal network communication on lis (in a goroutine)
+func (n *Node) StartServer(lis net.Listener) {
+	server := grpc.NewServer()
+	proto.RegisterNodeServer(server, &NodeServerImpl{Node: n})
+	go func() {
+		defer lis.Close()
+		err := server.Serve(lis)
+		utils.FailOnError("Failed to serve", err)
+	}()
+}
+
+// Contact the master node to join the network as a worker
+func (n *Node) Join(master string) (*proto.Join, error) {
+	client, err := utils.NodeCall(master)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	join, err := client.Client.NodeJoin(
+		client.Ctx,
+		&wrapperspb.StringValue{Value: n.Connection},
+	)
+	if err != nil {
+		return nil, err
+	}
+	n.InitializeWorker(master, join)
+	return join, nil
+}
+
 // From worker to master node to check if the master node is still alive
 func (s *NodeServerImpl) HealthCheck(_ context.Context, in *wrapperspb.StringValue) (*proto.Health, error) {
 	// utils.ServerLog("HealthCheck")
@@ -48,7 +80,7 @@ func (s *NodeServerImpl) HealthCheck(_ context.Context, in *wrapperspb.StringVal
 		s.Node.State.Others[id] = in.Value
 		// s.Node.State.Others = append(s.Node.State.Others, in.Value)VgVg
 		health.Value = &proto.Health_State{
-			State: s.Node.State,
+			State: sharedState(s.Node.State),
 		}
 	}
 	s.Node.mu.Unlock()
@@ -58,6 +90,9 @@ func (s *NodeServerImpl) HealthCheck(_ context.Context, in *wrapperspb.StringVal
 // From master to worker nodes to keep the master node shared on specific events
 func (s *NodeServerImpl) StateUpdate(_ context.Context, in *proto.State) (*emptypb.Empty, error) {
 	utils.ServerLog("StateUpdate")
+	s.Node.mu.Lock()
+	defer s.Node.mu.Unlock()
+	// Only the parameters of the job: the partitions are sent with the jobs
 	s.Node.State = in
 	return &emptypb.Empty{}, nil
 }
@@ -82,10 +117,11 @@ func (s *NodeServerImpl) NodeJoin(_ context.Context, in *wrapperspb.StringValue)
 	}
 	utils.NodeLog("master", "Assigning %s to %s", id, in.Value)
 	s.Node.State.Others[id] = in.Value
+	work, result := s.Node.Broker.Queues()
 	constants := proto.Join{
-		WorkQueue:   s.Node.Queue.Work.Name,
-		ResultQueue: s.Node.Queue.Result.Name,
-		State:       s.Node.State,
+		WorkQueue:   work,
+		ResultQueue: result,
+		State:       sharedState(s.Node.State),
 		Id:          id,
 	}
 	s.Node.mu.Unlock()
@@ -93,6 +129,30 @@ func (s *NodeServerImpl) NodeJoin(_ context.Context, in *wrapperspb.StringValue)
 	return &constants, nil
 }
 
+// From master to worker node to stream jobs (broker-less mode)
+func (s *NodeServerImpl) Compute(stream proto.Node_ComputeServer) error {
+	utils.ServerLog("Compute")
+	broker, ok := s.Node.Broker.(*StreamBroker)
+	if !ok {
+		return fmt.Errorf("Node is not using gRPC streams for jobs")
+	}
+	return broker.serve(stream)
+}
+
+// From worker to master node to get a partition the worker does not have
+func (s *NodeServerImpl) FetchPartition(_ context.Context, in *proto.PartitionRequest) (*proto.Partition, error) {
+	utils.ServerLog("FetchPartition: %s/%d", in.Partitioning, in.Sequence)
+	job := s.Node.currentJob()
+	if job == nil {
+		return &proto.Partition{}, fmt.Errorf("Unknown partitioning %s", in.Partitioning)
+	}
+	nodes, err := job.partition(in.Partitioning, in.Sequence)
+	if err != nil {
+		return &proto.Partition{}, err
+	}
+	return &proto.Partition{Nodes: nodes}, nil
+}
+
 // From worker node to worker nodes to announce a new candidacy
 func (s *NodeServerImpl) MasterCandidate(_ context.Context, in *proto.Candidacy) (*wrapperspb.BoolValue, error) {
 	utils.ServerLog("MasterCandidate")
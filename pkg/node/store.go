@@ -0,0 +1,327 @@
+package node
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Completed jobs saved on disk, one directory per job:
+//   - config.json: PageRank parameters (state without the graph)
+//   - job.json: metadata (iterations, delta, nodes, submission time, ...)
+//   - ranks.bin: rank vector (id int32, rank float64; little endian, sorted by id)
+//   - edges.bin: edges of the graph (from int32, to int32; sorted)
+//   - labels.json: node labels (only if the nodes are not integers)
+//
+// Checkpoints of the running jobs are saved in the same directory (see checkpoint.go)
+type Store struct {
+	Dir    string
+	mu     sync.Mutex // Thread Safety for cached
+	cached *results   // Last loaded final ranks (the pages of a job are requested one after another)
+}
+
+// Metadata of a stored job
+type storedJob struct {
+	Job       string    `json:"job"`
+	Status    string    `json:"status"` // Convergence status (e.g. Converged after 10 iterations)
+	Master    string    `json:"master"` // API of the master that computed the job
+	Iteration int32     `json:"iteration"`
+	Delta     float64   `json:"delta"`
+	Nodes     int       `json:"nodes"`
+	Edges     int       `json:"edges"`
+	Submitted time.Time `json:"submitted"`
+	Completed time.Time `json:"completed"`
+}
+
+// Open (or create) the store in dir; jobs whose saving was interrupted are removed
+// (the saved job they were replacing is restored)
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".tmp") {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, err
+			}
+		} else if old, ok := strings.CutSuffix(name[1:], ".old"); ok {
+			// Replaced directory: restored if the new one was not renamed yet
+			target := filepath.Join(dir, old)
+			if _, err := os.Stat(target); os.IsNotExist(err) {
+				err = os.Rename(path, target)
+			} else {
+				err = os.RemoveAll(path)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// Save the parameters and the results of a completed job
+// (written in a temporary directory, then renamed)
+func (s *Store) Save(job *Job) error {
+	// The results are not changed once the job is completed:
+	// only the metadata is read under the lock
+	job.mu.Lock()
+	results := job.results
+	config := &proto.State{
+		C:            job.State.C,
+		Threshold:    job.State.Threshold,
+		Partitioning: job.State.Partitioning,
+		Dangling:     job.State.Dangling,
+		Seed:         job.State.Seed,
+	}
+	meta := storedJob{
+		Job:       job.Id,
+		Iteration: job.State.Iteration,
+		Delta:     job.Delta,
+		Nodes:     job.Nodes,
+		Submitted: job.Submitted,
+	}
+	job.mu.Unlock()
+	if results == nil {
+		return fmt.Errorf("Job %s has no results", job.Id)
+	}
+	meta.Status = results.ranks.Status
+	meta.Master = results.ranks.Master
+	meta.Edges = len(results.ranks.Edges) / 2
+	meta.Completed = time.Now()
+	tmp := filepath.Join(s.Dir, fmt.Sprintf(".%s.tmp", job.Id))
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(tmp, "config.json"), data); err != nil {
+		return err
+	}
+	if err := writeJSON(filepath.Join(tmp, "job.json"), meta); err != nil {
+		return err
+	}
+	ranks := make([]byte, 0, 12*len(results.byID))
+	for _, id := range results.byID {
+		ranks = binary.LittleEndian.AppendUint32(ranks, uint32(id))
+		ranks = binary.LittleEndian.AppendUint64(ranks, math.Float64bits(results.ranks.Ranks[id]))
+	}
+	if err := writeFile(filepath.Join(tmp, "ranks.bin"), ranks); err != nil {
+		return err
+	}
+	edges := make([]byte, 0, 4*len(results.ranks.Edges))
+	for _, v := range results.ranks.Edges {
+		edges = binary.LittleEndian.AppendUint32(edges, uint32(v))
+	}
+	if err := writeFile(filepath.Join(tmp, "edges.bin"), edges); err != nil {
+		return err
+	}
+	if len(results.ranks.Labels) > 0 {
+		if err := writeJSON(filepath.Join(tmp, "labels.json"), results.ranks.Labels); err != nil {
+			return err
+		}
+	}
+	// A job computed again (e.g. by a new master) replaces the stored one
+	s.mu.Lock()
+	if s.cached != nil && s.cached.ranks.Job == job.Id {
+		s.cached = nil
+	}
+	s.mu.Unlock()
+	return replaceDir(tmp, filepath.Join(s.Dir, job.Id))
+}
+
+// Stored jobs (without their results); unreadable jobs are returned as errors
+func (s *Store) List() ([]*Job, []error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var jobs []*Job
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, checkpointSuffix) {
+			continue
+		}
+		job, err := s.job(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Job %s: %v", name, err))
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, errs
+}
+
+// Completed job with the metadata of the stored one: the ranks are
+// loaded on the first request
+func (s *Store) job(id string) (*Job, error) {
+	dir := filepath.Join(s.Dir, id)
+	var meta storedJob
+	if err := readJSON(filepath.Join(dir, "job.json"), &meta); err != nil {
+		return nil, err
+	}
+	if meta.Job != id {
+		return nil, fmt.Errorf("Metadata belongs to job %s", meta.Job)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	state := &proto.State{}
+	if err := protojson.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	state.Job = id
+	state.Iteration = meta.Iteration
+	return &Job{
+		Id:        id,
+		State:     state,
+		Phase:     Wait,
+		Status:    proto.JobStatus_JOB_COMPLETED,
+		Nodes:     meta.Nodes,
+		Submitted: meta.Submitted,
+		Delta:     meta.Delta,
+		Result: &proto.Ranks{
+			Master: meta.Master,
+			Status: meta.Status,
+			Job:    id,
+			Seed:   state.Seed,
+		},
+		store: s,
+	}, nil
+}
+
+// Final ranks of a stored job (the last loaded ones are kept in memory)
+func (s *Store) results(id string) (*results, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && s.cached.ranks.Job == id {
+		return s.cached, nil
+	}
+	ranks := &proto.Ranks{Job: id}
+	if err := s.Load(id, ranks); err != nil {
+		return nil, err
+	}
+	s.cached = newResults(ranks)
+	return s.cached, nil
+}
+
+// Load the results of a stored job in ranks (rank vector, edges and labels)
+func (s *Store) Load(id string, ranks *proto.Ranks) error {
+	dir := filepath.Join(s.Dir, id)
+	data, err := os.ReadFile(filepath.Join(dir, "ranks.bin"))
+	if err != nil {
+		return err
+	}
+	if len(data)%12 != 0 {
+		return fmt.Errorf("Corrupted rank vector of job %s", id)
+	}
+	ranks.Ranks = make(map[int32]float64, len(data)/12)
+	for i := 0; i < len(data); i += 12 {
+		node := int32(binary.LittleEndian.Uint32(data[i:]))
+		ranks.Ranks[node] = math.Float64frombits(binary.LittleEndian.Uint64(data[i+4:]))
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "edges.bin"))
+	if err != nil {
+		return err
+	}
+	if len(data)%8 != 0 {
+		return fmt.Errorf("Corrupted edges of job %s", id)
+	}
+	ranks.Edges = make([]int32, 0, len(data)/4)
+	for i := 0; i < len(data); i += 4 {
+		ranks.Edges = append(ranks.Edges, int32(binary.LittleEndian.Uint32(data[i:])))
+	}
+	err = readJSON(filepath.Join(dir, "labels.json"), &ranks.Labels)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// Write the file and flush it to disk (it is renamed afterwards)
+func writeFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Flush the entries of the directory to disk (created or renamed files)
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// Replace dir with tmp (completely written): the old directory is renamed
+// aside before, so that there is always one of them (see OpenStore)
+func replaceDir(tmp, dir string) error {
+	if err := syncDir(tmp); err != nil {
+		return err
+	}
+	old := filepath.Join(filepath.Dir(dir), fmt.Sprintf(".%s.old", filepath.Base(dir)))
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+func readJSON(path string, value any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Submit a job and complete it with the ranks of its graph
+func completeJob(s *Scheduler, labels []string) *Job {
+	state := testState()
+	state.Labels = labels
+	job := s.Submit(state)
+	s.Next()
+	ranks := &proto.Ranks{Job: job.Id, Status: "Converged after 1 iterations", Ranks: make(map[int32]float64)}
+	for id, u := range state.Graph {
+		ranks.Ranks[id] = u.Rank
+	}
+	ranks.Edges = []int32{0, 1, 1, 2, 2, 0}
+	if len(labels) > 0 {
+		ranks.Labels = map[int32]string{0: labels[0], 1: labels[1], 2: labels[2]}
+	}
+	job.finish(proto.JobStatus_JOB_COMPLETED, ranks)
+	return job
+}
+
+func TestStoreSaveList(t *testing.T) {
+	dir := t.TempDir()
+	var s Scheduler
+	if err := s.Open(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []*Job{completeJob(&s, nil), completeJob(&s, []string{"a", "b", "c"})}
+	for _, job := range jobs {
+		if err := s.Save(job); err != nil {
+			t.Fatal(err)
+		}
+		// Saved ranks are not kept in memory
+		if job.results != nil || len(job.Result.GetRanks()) > 0 {
+			t.Errorf("job %s: ranks kept in memory", job.Id)
+		}
+	}
+	// Restored by a new scheduler (e.g. after a restart)
+	var restored Scheduler
+	if err := restored.Open(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.List()) != len(jobs) {
+		t.Fatalf("expected %d jobs, got %d", len(jobs), len(restored.List()))
+	}
+	for i, saved := range jobs {
+		for _, s := range []*Scheduler{&s, &restored} {
+			job := s.Get(saved.Id)
+			if job == nil {
+				t.Fatalf("job %s not restored", saved.Id)
+			}
+			info := job.info()
+			if info.Status != proto.JobStatus_JOB_COMPLETED || info.Nodes != 3 || info.C != 0.85 {
+				t.Errorf("job %s: unexpected info %v", saved.Id, info)
+			}
+			page, err := job.ranks(&proto.RanksRequest{Order: proto.RanksOrder_ORDER_ID, Edges: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Nodes) != 3 || len(page.Edges) != 6 {
+				t.Fatalf("job %s: expected 3 nodes with 1 edge each, got %v", saved.Id, page)
+			}
+			for _, node := range page.Nodes {
+				if node.Rank != 1.0/3 {
+					t.Errorf("job %s: node %d has rank %f", saved.Id, node.Id, node.Rank)
+				}
+				if i == 1 && node.Label != []string{"a", "b", "c"}[node.Id] {
+					t.Errorf("job %s: node %d has label %q", saved.Id, node.Id, node.Label)
+				}
+			}
+		}
+	}
+}
+
+func TestStoreKeepsStoredJobs(t *testing.T) {
+	var s Scheduler
+	if err := s.Open(t.TempDir(), false); err != nil {
+		t.Fatal(err)
+	}
+	var jobs []*Job
+	for i := 0; i < keptJobs+5; i++ {
+		job := completeJob(&s, nil)
+		if err := s.Save(job); err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	s.Submit(testState())
+	for _, job := range jobs {
+		if s.Get(job.Id) == nil {
+			t.Errorf("stored job %s was pruned", job.Id)
+		}
+	}
+}
+
+func TestOpenStoreRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string // Directories in the store before opening it
+		exist   []string // Directories after opening it
+		removed []string
+	}{
+		{"interrupted save", []string{"job", ".job.tmp"}, []string{"job"}, []string{".job.tmp"}},
+		{"interrupted replace", []string{".job.old"}, []string{"job"}, []string{".job.old"}},
+		{"replaced", []string{"job", ".job.old"}, []string{"job"}, []string{".job.old"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, entry := range test.entries {
+				if err := os.Mkdir(filepath.Join(dir, entry), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := OpenStore(dir); err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range test.exist {
+				if _, err := os.Stat(filepath.Join(dir, entry)); err != nil {
+					t.Errorf("expected %s: %v", entry, err)
+				}
+			}
+			for _, entry := range test.removed {
+				if _, err := os.Stat(filepath.Join(dir, entry)); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be removed", entry)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lioia/distributed-pagerank/pkg/utils"
+	"github.com/lioia/distributed-pagerank/proto"
+)
+
+// Broker-less implementation of Broker: the master streams jobs directly
+// to the workers (Node.Compute), which stream the results back
+type StreamBroker struct {
+	mu      sync.Mutex
+	node    *Node
+	local   *MemoryBroker            // Jobs received by this worker, results received by this master
+	streams map[string]*workerStream // Master: open streams (worker connection -> stream)
+	master  proto.Node_ComputeServer // Worker: stream opened by the master
+	sendMu  sync.Mutex               // Worker: results are sent one at a time
+}
+
+// Master: stream to a worker
+type workerStream struct {
+	mu      sync.Mutex
+	client  utils.Client[proto.NodeClient]
+	stream  proto.Node_ComputeClient
+	pending map[tagKey]*proto.Job // Jobs sent whose result was not received yet
+}
+
+type tagKey struct {
+	job       string
+	iteration int32
+	phase     int32
+	sequence  int32
+}
+
+func NewStreamBroker(n *Node) *StreamBroker {
+	return &StreamBroker{
+		node:    n,
+		local:   NewMemoryBroker(1024),
+		streams: make(map[string]*workerStream),
+	}
+}
+
+// Sub-jobs are assigned to workers by their sequence number;
+// if a worker cannot be reached, the next one is used
+func (b *StreamBroker) PublishWork(job *proto.Job) error {
+	return b.publish(job, "")
+}
+
+// Send the job to a worker other than exclude (a worker whose stream was closed)
+func (b *StreamBroker) publish(job *proto.Job, exclude string) error {
+	var workers []string
+	for _, worker := range b.node.workers() {
+		if worker != exclude {
+			workers = append(workers, worker)
+		}
+	}
+	if len(workers) == 0 {
+		return fmt.Errorf("No worker available")
+	}
+	start := int(job.GetTag().GetSequence())
+	for i := range workers {
+		worker := workers[(start+i)%len(workers)]
+		if err := b.send(worker, job); err != nil {
+			utils.NodeLog("master", "[WARN] Failed to stream job to %s: %v", worker, err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("Failed to stream job to any worker")
+}
+
+func (b *StreamBroker) ConsumeWork(consumer string) (<-chan Delivery[*proto.Job], error) {
+	return b.local.ConsumeWork(consumer)
+}
+
+func (b *StreamBroker) PublishResult(result *proto.Result) error {
+	master := b.masterStream()
+	// The master could be opening a new stream: waiting for it before failing
+	for i := 0; master == nil && i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		master = b.masterStream()
+	}
+	if master == nil {
+		// The job is re-added to the queue (and sent again by the master)
+		return fmt.Errorf("No stream from master")
+	}
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	return master.Send(result)
+}
+
+// Worker: stream opened by the master (nil if there is none)
+func (b *StreamBroker) masterStream() proto.Node_ComputeServer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.master
+}
+
+func (b *StreamBroker) ConsumeResult() (<-chan Delivery[*proto.Result], error) {
+	return b.local.ConsumeResult()
+}
+
+func (b *StreamBroker) Cancel(consumer string) error {
+	return b.local.Cancel(consumer)
+}
+
+func (b *StreamBroker) Purge() error {
+	b.mu.Lock()
+	for _, s := range b.streams {
+		s.mu.Lock()
+		s.pending = make(map[tagKey]*proto.Job)
+		s.mu.Unlock()
+	}
+	b.mu.Unlock()
+	return b.local.Purge()
+}
+
+func (b *StreamBroker) Queues() (string, string) {
+	return "stream-work", "stream-result"
+}
+
+// Master: send the job to the worker (opening the stream if required;
+// the worker is contacted without holding the broker lock)
+func (b *StreamBroker) send(worker string, job *proto.Job) error {
+	b.mu.Lock()
+	s, ok := b.streams[worker]
+	b.mu.Unlock()
+	if !ok {
+		opened, err := b.open(worker)
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		if s, ok = b.streams[worker]; !ok {
+			s = opened
+			b.streams[worker] = s
+			go b.receive(worker, s)
+		}
+		b.mu.Unlock()
+		if s != opened {
+			// Stream opened concurrently by another publish
+			opened.client.Close()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.stream.Send(job); err != nil {
+		return err
+	}
+	s.pending[tagOf(job.Tag)] = job
+	return nil
+}
+
+func (b *StreamBroker) open(worker string) (*workerStream, error) {
+	client, err := utils.NodeCall(worker)
+	if err != nil {
+		return nil, err
+	}
+	// The stream lives as long as the worker (no timeout)
+	stream, err := client.Client.Compute(context.Background())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &workerStream{
+		client:  client,
+		stream:  stream,
+		pending: make(map[tagKey]*proto.Job),
+	}, nil
+}
+
+// Master: read the results streamed back by the worker
+func (b *StreamBroker) receive(worker string, s *workerStream) {
+	for {
+		result, err := s.stream.Recv()
+		if err != nil {
+			utils.NodeLog("master", "[WARN] Stream to %s closed: %v", worker, err)
+			break
+		}
+		s.mu.Lock()
+		delete(s.pending, tagOf(result.Tag))
+		s.mu.Unlock()
+		_ = b.local.PublishResult(result)
+	}
+	b.mu.Lock()
+	if b.streams[worker] == s {
+		delete(b.streams, worker)
+	}
+	b.mu.Unlock()
+	s.client.Close()
+	// Worker crashed: its jobs are assigned to the other workers
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[tagKey]*proto.Job)
+	s.mu.Unlock()
+	for _, job := range pending {
+		if err := b.publish(job, worker); err != nil {
+			utils.NodeLog("master", "[WARN] Failed to reassign job: %v", err)
+		}
+	}
+}
+
+// Worker: receive the jobs streamed by the master
+func (b *StreamBroker) serve(stream proto.Node_ComputeServer) error {
+	b.mu.Lock()
+	b.master = stream
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		if b.master == stream {
+			b.master = nil
+		}
+		b.mu.Unlock()
+	}()
+	for {
+		job, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_ = b.local.PublishWork(job)
+	}
+}
+
+func tagOf(tag *proto.Tag) tagKey {
+	return tagKey{
+		job:       tag.GetJob(),
+		iteration: tag.GetIteration(),
+		phase:     tag.GetPhase(),
+		sequence:  tag.GetSequence(),
+	}
+}
@@ -1,14 +1,12 @@
 package node
 
 import (
-	"context"
 	"fmt"
 	"time"
 
+	"github.com/lioia/distributed-pagerank/pkg/graph"
 	"github.com/lioia/distributed-pagerank/pkg/utils"
 	"github.com/lioia/distributed-pagerank/proto"
-	amqp "github.com/rabbitmq/amqp091-go"
-	protobuf "google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -22,90 +20,116 @@ func (n *Node) workerUpdate() {
 }
 
 func readQueue(n *Node) {
-	// Register consumer
-	msgs, err := n.Queue.Channel.Consume(
-		n.Queue.Work.Name, // queue
-		n.Connection,      // consumer (connection used as an identifier)
-		false,             // auto-ack
-		false,             // exclusive
-		false,             // no-local
-		false,             // no-wait
-		nil,               // args
-	)
+	// Register consumer (connection used as an identifier)
+	msgs, err := n.Broker.ConsumeWork(n.Connection)
 	utils.FailOnError("Could not register a consumer", err)
-	utils.NodeLog("worker", "Registered consumer for queue %s", n.Queue.Work.Name)
+	work, _ := n.Broker.Queues()
+	utils.NodeLog("worker", "Registered consumer for queue %s", work)
 	// Queue Message Handler
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 	for {
 		select {
 		case <-n.QueueReader:
 			utils.NodeLog("worker", "Queue Reading goroutine canceled")
 			return
-		case d := <-msgs:
-			// Get data from bytes
-			var job proto.Job
-			err := protobuf.Unmarshal(d.Body, &job)
-			if err != nil {
-				utils.FailOnNack(d, err)
-				continue
+		case d, ok := <-msgs:
+			if !ok {
+				utils.NodeLog("worker", "Queue consumer closed")
+				return
 			}
-			result := proto.Result{}
+			job := d.Value
+			// The result is tagged as the job, so that the master can discard stale results
+			result := proto.Result{Tag: job.Tag}
 			// Create result value
 			// Handle job based on type
 			if job.Type == 0 {
-				utils.NodeLog("worker", "Computing Map Job (length %d)", len(job.MapData))
-				result.Values = workerMap(n, job.MapData)
+				subGraph, err := workerPartition(n, job)
+				if err != nil {
+					utils.FailOnNack(d.Nack, err)
+					continue
+				}
+				utils.NodeLog("worker", "Computing Map Job (length %d)", subGraph.Nodes)
+				result.Values = workerMap(subGraph, job.Ranks)
 				utils.NodeLog("worker", "Completed Map Job")
 			} else if job.Type == 1 {
 				utils.NodeLog("worker", "Computing Reduce Job (length %d)", len(job.ReduceData))
-				result.Values = workerReduce(n, job.ReduceData)
+				result.Values = workerReduce(job.C, job.ReduceData)
 				utils.NodeLog("worker", "Completed Reduce Job")
 			}
 			// Publish result to Result queue
-			data, err := protobuf.Marshal(&result)
-			if err != nil {
-				utils.FailOnNack(d, err)
-				continue
-			}
-			err = n.Queue.Channel.PublishWithContext(ctx,
-				"",
-				n.Queue.Result.Name, // routing key
-				false,               // mandatory
-				false,
-				amqp.Publishing{
-					DeliveryMode: amqp.Persistent,
-					ContentType:  "application/x-protobuf",
-					Body:         data,
-				})
-			if err != nil {
-				utils.FailOnNack(d, err)
+			if err := n.Broker.PublishResult(&result); err != nil {
+				utils.FailOnNack(d.Nack, err)
 				continue
 			}
 
 			// Ack
-			if err := d.Ack(false); err != nil {
-				utils.FailOnNack(d, err)
+			if err := d.Ack(); err != nil {
+				utils.FailOnNack(d.Nack, err)
 				continue
 			}
 		}
 	}
 }
 
-func workerMap(n *Node, subGraph map[int32]*proto.Map) map[int32]float64 {
-	contributions := make(map[int32]float64)
-	for id, u := range subGraph {
-		for _, v := range u.InLinks {
-			contributions[id] += v.Rank / float64(v.Outlinks)
-		}
+// Partition adjacency of a Map job: it is sent with the job on the first
+// iteration, then kept by the worker (or requested to the master if missing)
+func workerPartition(n *Node, job *proto.Job) (*graph.CSR, error) {
+	if n.Partitions.Job != job.Tag.GetJob() {
+		// New job: previous partitions are no longer needed
+		n.Partitions.Job = job.Tag.GetJob()
+		n.Partitions.Nodes = make(map[string]*graph.CSR)
+	}
+	key := fmt.Sprintf("%s/%d", job.Partitioning, job.Tag.GetSequence())
+	if len(job.MapData) > 0 {
+		n.Partitions.Nodes[key] = graph.NewPartitionCSR(job.MapData)
+		return n.Partitions.Nodes[key], nil
+	}
+	if subGraph, ok := n.Partitions.Nodes[key]; ok {
+		return subGraph, nil
+	}
+	utils.NodeLog("worker", "Requesting partition %s to master", key)
+	master, err := utils.NodeCall(n.Master)
+	if err != nil {
+		return nil, err
+	}
+	defer master.Close()
+	partition, err := master.Client.FetchPartition(master.Ctx, &proto.PartitionRequest{
+		Partitioning: job.Partitioning,
+		Sequence:     job.Tag.GetSequence(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.Partitions.Nodes[key] = graph.NewPartitionCSR(partition.Nodes)
+	return n.Partitions.Nodes[key], nil
+}
+
+func workerMap(subGraph *graph.CSR, ranks map[int32]float64) map[int32]float64 {
+	rank := make([]float64, len(subGraph.Ids))
+	for i, id := range subGraph.Ids {
+		rank[i] = ranks[id]
+	}
+	sum := subGraph.Contributions(rank)
+	contributions := make(map[int32]float64, len(sum))
+	for i, v := range sum {
+		contributions[subGraph.Ids[i]] = v
 	}
 	return contributions
 }
 
-func workerReduce(n *Node, reduce map[int32]*proto.Reduce) map[int32]float64 {
-	ranks := make(map[int32]float64)
+// Convergence is checked by the master
+func workerReduce(c float64, reduce map[int32]*proto.Reduce) map[int32]float64 {
+	ids := make([]int32, 0, len(reduce))
+	sum := make([]float64, 0, len(reduce))
+	e := make([]float64, 0, len(reduce))
 	for id, v := range reduce {
-		ranks[id] = n.State.C*v.Sum + (1-n.State.C)*v.E
+		ids = append(ids, id)
+		sum = append(sum, v.Sum)
+		e = append(e, v.E)
+	}
+	rank := graph.ReduceRanks(c, sum, e)
+	ranks := make(map[int32]float64, len(ids))
+	for i, id := range ids {
+		ranks[id] = rank[i]
 	}
 	return ranks
 }
@@ -179,11 +203,9 @@ func workerCandidacy(n *Node) {
 		// Stop goroutines
 		n.QueueReader <- true
 		// Empty queues
-		_, err := n.Queue.Channel.QueuePurge(n.Queue.Work.Name, true)
-		utils.FailOnError("Failed to empty %s queue", err, n.Queue.Work.Name)
-		_, err = n.Queue.Channel.QueuePurge(n.Queue.Result.Name, true)
-		utils.FailOnError("Failed to empty %s queue", err, n.Queue.Result.Name)
-		err = n.Queue.Channel.Cancel(n.Connection, true)
+		err := n.Broker.Purge()
+		utils.FailOnError("Failed to empty queues", err)
+		err = n.Broker.Cancel(n.Connection)
 		utils.FailOnError("Failed to cancel queue reading channel", err)
 		// Switch to master
 		n.Role = Master
@@ -12,6 +12,7 @@ type EnvVars struct {
 	Master      string
 	Host        string
 	Port        int
+	Broker      string
 	RabbitHost  string
 	RabbitUser  string
 	RabbitPass  string
@@ -30,16 +31,20 @@ func ReadEnvVars() EnvVars {
 	host := ReadStringEnvVarOr("HOST", "")
 	port, err := ReadIntEnvVar("PORT")
 	FailOnError("Failed to read environment variables", err)
-	rabbitHost, err := ReadStringEnvVar("RABBIT_HOST")
-	FailOnError("Failed to read environment variables", err)
+	broker := ReadStringEnvVarOr("BROKER", "rabbitmq")
+	rabbitHost := ""
+	if broker == "rabbitmq" {
+		rabbitHost, err = ReadStringEnvVar("RABBIT_HOST")
+		FailOnError("Failed to read environment variables", err)
+	}
 	rabbitUser := ReadStringEnvVarOr("RABBIT_USER", "guest")
 	rabbitPass := ReadStringEnvVarOr("RABBIT_PASSWORD", "guest")
 	workQueue := ReadStringEnvVarOr("WORK_QUEUE", "work")
 	resultQueue := ReadStringEnvVarOr("RESULT_QUEUE", "result")
-	nodeLog := readBoolEnvVarOr("NODE_LOG", false)
-	serverLog := readBoolEnvVarOr("SERVER_LOG", false)
+	nodeLog := ReadBoolEnvVarOr("NODE_LOG", false)
+	serverLog := ReadBoolEnvVarOr("SERVER_LOG", false)
 	return EnvVars{
-		Master: master, Host: host, Port: port,
+		Master: master, Host: host, Port: port, Broker: broker,
 		RabbitHost: rabbitHost, RabbitUser: rabbitUser, RabbitPass: rabbitPass,
 		WorkQueue: workQueue, ResultQueue: resultQueue,
 		NodeLog: nodeLog, ServerLog: serverLog,
@@ -82,7 +87,7 @@ func ReadIntEnvVarOr(name string, or int) int {
 	return value
 }
 
-func readBoolEnvVarOr(name string, or bool) bool {
+func ReadBoolEnvVarOr(name string, or bool) bool {
 	valueStr, err := ReadStringEnvVar(name)
 	if err != nil {
 		return or
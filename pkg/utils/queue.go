@@ -25,10 +25,10 @@ func DeclareQueue(name string, ch *amqp.Channel) (queue amqp.Queue, err error) {
 	return
 }
 
-func FailOnNack(d amqp.Delivery, err error) {
-	fmt.Printf("Could not marshal result: %v", err)
+func FailOnNack(nack func() error, err error) {
+	fmt.Printf("Could not process message: %v\n", err)
 	// Message will be re-added to the queue
-	if err = d.Nack(false, true); err != nil {
+	if err = nack(); err != nil {
 		log.Fatalf("Could not NACK to message queue: %v", err)
 	}
 }